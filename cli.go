@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/secberus-oss/projector/utils"
+	"github.com/spf13/viper"
+)
+
+// staleHookURLs returns the old projector URLs whose hooks are removed
+func staleHookURLs() []string {
+	var urls []string
+	for _, url := range strings.Split(viper.GetString("stale_hook_urls"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// reconcileHooks runs the reconcile-hooks command, printing the hook changes
+// as JSON and returning the exit code
+func reconcileHooks(args []string) int {
+	flags := flag.NewFlagSet("reconcile-hooks", flag.ContinueOnError)
+	rotate := flags.Bool("rotate-secret", false, "push PRJ_HOOK_SECRET to the hook even if it has a secret")
+	dryRun := flags.Bool("dry-run", false, "print the changes without making them")
+	prune := flags.String("prune", "", "comma separated stale hook URLs to remove, defaults to PRJ_STALE_HOOK_URLS")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	configure()
+	if *prune != "" {
+		viper.Set("stale_hook_urls", *prune)
+	}
+	changes, err := utils.NewGH().ReconcileHooks(utils.HookOptions{
+		RotateSecret: *rotate,
+		StaleURLs:    staleHookURLs(),
+		DryRun:       *dryRun,
+	})
+	out, _ := json.MarshalIndent(changes, "", "  ")
+	fmt.Println(string(out))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
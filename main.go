@@ -1,86 +1,886 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	github "github.com/google/go-github/v32/github"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/robfig/cron/v3"
 	"github.com/secberus-oss/projector/utils"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
+// readinessTTL is how long readiness check results are reused
+const readinessTTL = 30 * time.Second
+
+// queueDrainTimeout is how long queued deliveries are processed for on
+// shutdown before the rest is dead-lettered
+const queueDrainTimeout = 20 * time.Second
+
 // PRJ stores projector metadata
 type PRJ struct {
 	gh            *utils.GH
 	RuleProcessor *utils.RulesProcessor
+	History       *utils.CardHistory
+	Store         *utils.Store
+	Queue         *utils.Queue
+	Auth          *utils.Authenticator
+	readyMu       sync.Mutex
+	readyAt       time.Time
+	readyChecks   []utils.Check
+	cron          *cron.Cron
+	reportsMu     sync.Mutex
+	latestReports []utils.Report
+	latestAt      time.Time
 }
 
-// NewPRJ creates a new instance of PRJ
-func NewPRJ() *PRJ {
+// configure reads the settings from PRJ_ environment variables
+func configure() {
 	viper.SetEnvPrefix("prj") // will be uppercased automatically
 	viper.AutomaticEnv()
+	viper.SetDefault("archive_schedule", "@daily")
+	viper.SetDefault("stale_schedule", "@hourly")
+	viper.SetDefault("cycle_start_column", "In Progress")
+	viper.SetDefault("store_path", "projector.db")
+	viper.SetDefault("report_period", "7d")
+	viper.SetDefault("report_concurrency", 4)
+	viper.SetDefault("done_columns", "Done")
+	viper.SetDefault("log_level", "info")
+	viper.SetDefault("log_format", "logfmt")
+	viper.SetDefault("queue_size", 500)
+	viper.SetDefault("queue_workers", 4)
+	viper.SetDefault("queue_max_attempts", 3)
+	viper.SetDefault("snapshot_retention", 52)
+	// settings without a default are bound so they show in the effective config
+	for _, key := range []string{"org_name", "default_project", "default_column", "hook_url", "hook_secret",
+		"github_token", "github_api_url", "report_schedule", "report_window", "admin_tokens", "admin_github_auth",
+		"hook_previous_secret", "stale_hook_urls", "hook_mode", "hook_repos"} {
+		viper.BindEnv(key)
+	}
+	utils.ConfigureLogging()
+	if err := utils.CheckHookMode(); err != nil {
+		logrus.WithError(err).Fatal("Invalid hook config")
+	}
+}
+
+// NewPRJ creates a new instance of PRJ
+func NewPRJ() *PRJ {
+	configure()
+	gh := utils.NewGH()
 	prj := PRJ{
-		gh:            utils.NewGH(),
-		RuleProcessor: utils.NewRulesProcessor(),
+		gh:            gh,
+		RuleProcessor: utils.NewRulesProcessor(gh),
+	}
+	store, err := utils.NewStore(viper.GetString("store_path"))
+	if err != nil {
+		logrus.WithError(err).Warn("Unable to open store, history won't be persisted")
+	} else {
+		prj.Store = store
+	}
+	prj.History = utils.NewCardHistory(prj.Store)
+	prj.RuleProcessor.SetStore(prj.Store)
+	prj.Queue = utils.NewQueue(viper.GetInt("queue_size"), prj.processDelivery)
+	prj.Queue.MaxAttempts = viper.GetInt("queue_max_attempts")
+	prj.Queue.OnGiveUp = prj.deadLetter
+	prj.Auth = utils.NewAuthenticator(prj.gh)
+	if !prj.Auth.Enabled() {
+		logrus.Warn("Neither PRJ_ADMIN_TOKENS nor PRJ_ADMIN_GITHUB_AUTH is set, /admin and /reports will refuse every request")
 	}
 	return &prj
 }
 
+// deadLetter stores a delivery that failed every attempt so it can be replayed
+func (p *PRJ) deadLetter(j *utils.Job) {
+	if p.Store == nil {
+		return
+	}
+	d := utils.DeadLetter{
+		DeliveryID: j.DeliveryID,
+		Event:      j.EventType,
+		Payload:    j.Payload,
+		Error:      j.LastError,
+		Attempts:   j.Attempts,
+		FailedAt:   time.Now(),
+		Done:       j.Done,
+	}
+	if err := p.Store.SaveDeadLetter(d); err != nil {
+		logrus.WithError(err).WithField("delivery", j.DeliveryID).Error("Error Storing Dead Letter")
+	}
+}
+
+// replay queues dead-lettered deliveries again, oldest first. They are removed
+// from the store before being queued so a replay failing again is kept, and
+// stored again when they can't be queued.
+func (p *PRJ) replay(letters []utils.DeadLetter) ([]string, error) {
+	replayed := []string{}
+	for i := len(letters) - 1; i >= 0; i-- {
+		d := letters[i]
+		if err := p.Store.DeleteDeadLetter(d.DeliveryID); err != nil {
+			return replayed, err
+		}
+		j := &utils.Job{DeliveryID: d.DeliveryID, EventType: d.Event, Payload: d.Payload, Replayed: true, Done: d.Done}
+		if err := p.Queue.Enqueue(j); err != nil {
+			if sErr := p.Store.SaveDeadLetter(d); sErr != nil {
+				logrus.WithError(sErr).WithField("delivery", d.DeliveryID).Error("Error Storing Dead Letter")
+			}
+			return replayed, err
+		}
+		replayed = append(replayed, d.DeliveryID)
+	}
+	return replayed, nil
+}
+
+// processDelivery applies the rules to a queued webhook delivery
+func (p *PRJ) processDelivery(j *utils.Job) error {
+	start := time.Now()
+	defer func() { utils.WebhookDuration.WithLabelValues(j.EventType).Observe(time.Since(start).Seconds()) }()
+	event, err := github.ParseWebHook(j.EventType, j.Payload)
+	if err != nil {
+		return err
+	}
+	action := ""
+	if e, ok := event.(interface{ GetAction() string }); ok {
+		action = e.GetAction()
+	}
+	l := logrus.WithFields(logrus.Fields{"delivery": j.DeliveryID, "event": j.EventType, "action": action, "attempt": j.Attempts}).WithFields(utils.EventFields(event))
+	gh, rules := p.gh.WithLogger(l), p.RuleProcessor.WithLogger(l)
+	l.Debug("Processing delivery")
+	// steps that succeeded are skipped when the delivery is retried
+	errs := []error{
+		j.Step("label_rules", func() error { return rules.ProcessLabelRules(event) }),
+		j.Step("lifecycle_rules", func() error { return rules.ProcessLifecycleRules(event, j.Payload) }),
+	}
+	switch event := event.(type) {
+	case *github.PullRequestEvent:
+		errs = append(errs, j.Step("pull_request", func() error { return gh.ProccessPullRequestEvent(event) }))
+	case *github.IssuesEvent:
+		errs = append(errs, j.Step("issues", func() error { return gh.ProccessIssuesEvent(event) }))
+	case *github.ProjectCardEvent:
+		if j.Attempts == 1 && !j.Replayed {
+			p.History.Record(event)
+		}
+		errs = append(errs, j.Step("project_card", func() error { return rules.ProcessProjectCardEvent(event) }))
+	case *github.IssueCommentEvent:
+		errs = append(errs, j.Step("issue_comment", func() error { return rules.ProcessIssueCommentEvent(event) }))
+	}
+	for _, err := range errs {
+		if err != nil {
+			utils.WebhookDeliveries.WithLabelValues(j.EventType, action, "failed").Inc()
+			return err
+		}
+	}
+	l.Info("Processed delivery")
+	utils.WebhookDeliveries.WithLabelValues(j.EventType, action, "processed").Inc()
+	return nil
+}
+
+// recordDelivery stores a webhook delivery, returning false for deliveries
+// that were already received
+func (p *PRJ) recordDelivery(l *logrus.Entry, deliveryID string, eventType string, event interface{}) bool {
+	if p.Store == nil || deliveryID == "" {
+		return true
+	}
+	recorded, err := p.Store.RecordDelivery(utils.NewDelivery(deliveryID, eventType, event))
+	if err != nil {
+		l.WithError(err).Error("Error Storing Delivery")
+		return true
+	}
+	return recorded
+}
+
+// forgetDelivery removes a delivery that couldn't be queued, so GitHub's
+// redelivery of it is processed
+func (p *PRJ) forgetDelivery(l *logrus.Entry, deliveryID string) {
+	if p.Store == nil || deliveryID == "" {
+		return
+	}
+	if err := p.Store.DeleteDelivery(deliveryID); err != nil {
+		l.WithError(err).Error("Error Removing Delivery")
+	}
+}
+
+// recordTransition stores the state change a queued delivery carries
+func (p *PRJ) recordTransition(l *logrus.Entry, event interface{}) {
+	if p.Store == nil {
+		return
+	}
+	if t, ok := utils.NewIssueTransition(event); ok {
+		if err := p.Store.RecordIssueTransition(t); err != nil {
+			l.WithError(err).Error("Error Storing Issue Transition")
+		}
+	}
+}
+
+// requireAuth rejects requests without a valid bearer token
+func (p *PRJ) requireAuth(c *gin.Context) {
+	user, err := "", utils.ErrUnauthorized
+	if auth := strings.SplitN(c.GetHeader("Authorization"), " ", 2); len(auth) == 2 && strings.EqualFold(auth[0], "Bearer") {
+		user, err = p.Auth.Authenticate(strings.TrimSpace(auth[1]))
+	}
+	if err != nil {
+		if err != utils.ErrUnauthorized {
+			logrus.WithError(err).Error("Unable to authenticate request")
+		}
+		c.AbortWithStatusJSON(401, gin.H{"error": utils.ErrUnauthorized.Error()})
+		return
+	}
+	c.Set("user", user)
+	c.Next()
+}
+
+// effectiveConfig returns the settings in use with secrets redacted
+func (p *PRJ) effectiveConfig() gin.H {
+	return gin.H{"Settings": utils.Redact(viper.AllSettings()), "Rules": p.RuleProcessor.RulesConfig()}
+}
+
+// projectColumns lists the projects of the org with their columns
+func (p *PRJ) projectColumns() (gin.H, error) {
+	projects := gin.H{}
+	for _, project := range p.gh.ListProjects() {
+		columns, err := p.gh.ListProjectColumns(project.GetID())
+		if err != nil {
+			return nil, err
+		}
+		names := make([]gin.H, len(columns))
+		for i, column := range columns {
+			names[i] = gin.H{"ID": column.GetID(), "Name": column.GetName()}
+		}
+		projects[project.GetName()] = gin.H{"ID": project.GetID(), "Columns": names}
+	}
+	return projects, nil
+}
+
+// labelRuleChange answers a label rule change, Persisted is false when it
+// lasts only until restart
+type labelRuleChange struct {
+	utils.LabelRule
+	Persisted bool `json:"Persisted"`
+}
+
+// labelRuleError answers a failed label rule change
+func labelRuleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, utils.ErrRuleNotFound):
+		c.JSON(404, gin.H{"error": err.Error()})
+	case errors.Is(err, utils.ErrRuleExists):
+		c.JSON(409, gin.H{"error": err.Error()})
+	default:
+		c.JSON(400, gin.H{"error": err.Error()})
+	}
+}
+
+// Close stops the background jobs, drains the webhook queue and closes the
+// store
+func (p *PRJ) Close() {
+	if p.cron != nil {
+		<-p.cron.Stop().Done()
+	}
+	// deliveries still pending are dead-lettered while the store is open
+	ctx, cancel := context.WithTimeout(context.Background(), queueDrainTimeout)
+	defer cancel()
+	if err := p.Queue.Stop(ctx); err != nil {
+		logrus.WithError(err).Warn("Webhook queue not drained, pending deliveries were dead-lettered")
+	}
+	if p.Store != nil {
+		if err := p.Store.Close(); err != nil {
+			logrus.WithError(err).Error("Error closing store")
+		}
+	}
+}
+
 // CheckHealth used to see if the service is up
 func (p *PRJ) CheckHealth() int {
 	return 200
 }
 
+// CheckReadiness checks GitHub can be reached with the token, the default
+// project and column and the projects of the rules were found, the org hook
+// is active and the rules config loaded. Results are reused for readinessTTL.
+func (p *PRJ) CheckReadiness() (bool, []utils.Check) {
+	p.readyMu.Lock()
+	defer p.readyMu.Unlock()
+	if time.Since(p.readyAt) > readinessTTL {
+		project, ruleProjects := p.gh.CheckDefaultProject(), p.RuleProcessor.CheckRuleProjects()
+		if project != nil || ruleProjects != nil {
+			// try again, GitHub may have been unreachable at startup or the
+			// projects created since. The rules share the reloaded projects.
+			if err := p.loadConfig(); err != nil {
+				project = err
+			} else {
+				project = p.gh.CheckDefaultProject()
+			}
+			ruleProjects = p.RuleProcessor.CheckRuleProjects()
+		}
+		p.readyChecks = []utils.Check{
+			utils.NewCheck("token", p.gh.CheckToken()),
+			utils.NewCheck("default_project", project),
+			utils.NewCheck("hook", p.gh.CheckHook()),
+			utils.NewCheck("rules", p.RuleProcessor.CheckRules()),
+			utils.NewCheck("rule_projects", ruleProjects),
+		}
+		p.readyAt = time.Now()
+	}
+	for _, c := range p.readyChecks {
+		if !c.OK {
+			return false, p.readyChecks
+		}
+	}
+	return true, p.readyChecks
+}
+
 // RunReports used to run reports
-func (p *PRJ) RunReports() []utils.Report {
-	log.Print("Running Reports...")
-	r := utils.NewReporter()
-	r.GenerateReports(p.gh.Projects)
+func (p *PRJ) RunReports(window utils.ReportWindow, period time.Duration, snapshot bool, dimensions ...string) []utils.Report {
+	logrus.Info("Running Reports")
+	r := p.newReporter()
+	r.Window = window
+	r.Period = period
+	r.Dimensions = dimensions
+	r.Snapshot = snapshot
+	r.GenerateReports(p.gh.OrgProjects())
 	return r.Reports
 }
 
+// saveSnapshot stores reports in the report history, keeping the newest
+// snapshot_retention snapshots
+func (p *PRJ) saveSnapshot(reports []utils.Report) {
+	if p.Store == nil {
+		return
+	}
+	if _, err := p.Store.SaveSnapshot(reports, time.Now()); err != nil {
+		logrus.WithError(err).Error("Error Storing Report Snapshot")
+		return
+	}
+	if keep := viper.GetInt("snapshot_retention"); keep > 0 {
+		if err := p.Store.PruneSnapshots(keep); err != nil {
+			logrus.WithError(err).Error("Error Pruning Report Snapshots")
+		}
+	}
+}
+
+// doneColumns returns the configured done columns of every project
+func doneColumns() []string {
+	var columns []string
+	for _, column := range strings.Split(viper.GetString("done_columns"), ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// newReporter creates a Reporter with the configured rules
+func (p *PRJ) newReporter() *utils.Reporter {
+	r := utils.NewReporter(p.gh)
+	r.Concurrency = viper.GetInt("report_concurrency")
+	r.DoneColumns = p.RuleProcessor.DoneColumns()
+	r.DefaultDoneColumns = doneColumns()
+	r.LabelGroups = p.RuleProcessor.LabelGroups()
+	r.History = p.History
+	r.CycleStartColumn = viper.GetString("cycle_start_column")
+	r.IncludeArchived = p.RuleProcessor.HasArchived()
+	r.WIPLimits = p.RuleProcessor.WIPLimits()
+	return r
+}
+
+// project finds an open project of the org by name
+func (p *PRJ) project(name string) *github.Project {
+	for _, project := range p.gh.OrgProjects() {
+		if project.GetName() == name {
+			return project
+		}
+	}
+	return nil
+}
+
+// chartWindow parses the window of a chart, the last 30 days by default
+func chartWindow(c *gin.Context) (time.Time, time.Time, error) {
+	now := time.Now()
+	last := c.Query("last")
+	if c.Query("since") == "" && last == "" {
+		last = "30d"
+	}
+	window, err := utils.ParseReportWindow(c.Query("since"), c.Query("until"), last, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if window.Until.IsZero() {
+		window.Until = now
+	}
+	return window.Since, window.Until, nil
+}
+
+// PublishReports generates the scheduled reports, caches them and publishes
+// them to the configured sinks
+func (p *PRJ) PublishReports() {
+	window, err := utils.ParseReportWindow("", "", viper.GetString("report_window"), time.Now())
+	if err != nil {
+		logrus.WithError(err).Error("Invalid report window")
+		return
+	}
+	period, err := utils.ParseDuration(viper.GetString("report_period"))
+	if err == nil {
+		err = utils.CheckVelocityPeriod(window, period, time.Now())
+	}
+	if err != nil {
+		logrus.WithError(err).Error("Invalid report period")
+		return
+	}
+	reports := p.RunReports(window, period, false)
+	p.saveSnapshot(reports)
+	p.reportsMu.Lock()
+	p.latestReports, p.latestAt = reports, time.Now()
+	p.reportsMu.Unlock()
+	utils.NewPublisher(p.gh).PublishAll(p.RuleProcessor.ReportSinks(), reports)
+}
+
+// LatestReports returns the last scheduled reports and when they were generated
+func (p *PRJ) LatestReports() ([]utils.Report, time.Time, bool) {
+	p.reportsMu.Lock()
+	defer p.reportsMu.Unlock()
+	return p.latestReports, p.latestAt, p.latestReports != nil
+}
+
 // loadConfig to get github things
-func (p *PRJ) loadConfig() {
-	p.gh.DefaultProjectID = *p.gh.GetProjectID(p.gh.DefaultProjectName)
-	p.gh.GetDefaultProjectColumns()
-	p.gh.GetDefaultColumnID()
-	if !p.gh.HookExists(p.gh.ListHooks()) {
-		p.gh.CreateHook()
+func (p *PRJ) loadConfig() error {
+	return p.gh.LoadDefaultProject()
+}
+
+// startupHooks reconciles the hooks once at startup, readiness checks only
+// report their drift
+func (p *PRJ) startupHooks() {
+	changes, err := p.gh.ReconcileHooks(utils.HookOptions{StaleURLs: staleHookURLs()})
+	if err != nil {
+		logrus.WithError(err).Error("Unable to reconcile hooks")
+	}
+	for _, c := range changes {
+		logrus.WithFields(logrus.Fields{"repo": c.Repo, "hook": c.HookID, "url": c.URL, "changes": strings.Join(c.Changes, ",")}).Info("Hook " + c.Action)
 	}
 }
 
-func main() {
-	prj := NewPRJ()
-	prj.loadConfig()
+// schedule returns the cron spec of a job, empty when it is set to off or to
+// an empty value. viper ignores empty environment variables, so they are
+// checked here to let them override the default schedules.
+func schedule(key string) string {
+	if v, ok := os.LookupEnv("PRJ_" + strings.ToUpper(key)); ok && strings.TrimSpace(v) == "" {
+		return ""
+	}
+	spec := viper.GetString(key)
+	if spec == "off" {
+		return ""
+	}
+	return spec
+}
+
+// scheduleJobs starts the background jobs
+func (p *PRJ) scheduleJobs() {
+	p.cron = cron.New()
+	jobs := map[string]func(){
+		"archive_schedule": p.RuleProcessor.ArchiveStaleCards,
+		"stale_schedule":   p.RuleProcessor.SweepStaleCards,
+		"report_schedule":  p.PublishReports,
+	}
+	for key, job := range jobs {
+		spec := schedule(key)
+		if spec == "" {
+			logrus.WithField("job", key).Info("Job disabled")
+			continue
+		}
+		if _, err := p.cron.AddFunc(spec, job); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"job": key, "schedule": spec}).Error("Invalid schedule")
+		}
+	}
+	p.cron.Start()
+}
+
+// Router serves the health checks, webhooks, reports and admin API
+func (p *PRJ) Router() *gin.Engine {
 	r := gin.Default()
 	r.GET("/", func(c *gin.Context) {
-		c.JSON(prj.CheckHealth(), gin.H{
+		c.JSON(p.CheckHealth(), gin.H{
 			"status": "ok",
 		})
 	})
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(p.CheckHealth(), gin.H{
+			"status": "ok",
+		})
+	})
+	r.GET("/readyz", func(c *gin.Context) {
+		ready, checks := p.CheckReadiness()
+		if !ready {
+			c.JSON(503, gin.H{"status": "not ready", "checks": checks})
+			return
+		}
+		c.JSON(200, gin.H{"status": "ok", "checks": checks})
+	})
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	r.POST("/webhook", func(c *gin.Context) {
-		payload, _ := github.ValidatePayload(c.Request, prj.gh.Secret)
-		event, err := github.ParseWebHook(github.WebHookType(c.Request), payload)
+		eventType := github.WebHookType(c.Request)
+		deliveryID := github.DeliveryID(c.Request)
+		l := logrus.WithFields(logrus.Fields{"delivery": deliveryID, "event": eventType})
+		payload, err := p.gh.ValidatePayload(c.Request)
+		if err != nil {
+			l.WithError(err).Warn("Invalid webhook signature")
+			utils.WebhookDeliveries.WithLabelValues(eventType, "", "unauthorized").Inc()
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+		event, err := github.ParseWebHook(eventType, payload)
 		if err != nil {
-			log.Println(err)
+			l.WithError(err).Warn("Unable to parse webhook")
+			utils.WebhookDeliveries.WithLabelValues(eventType, "", "invalid").Inc()
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
 		}
-		prj.RuleProcessor.ProcessLabelRules(event)
-		switch event := event.(type) {
-		case *github.PullRequestEvent:
-			prj.gh.ProccessPullRequestEvent(event)
-		case *github.IssuesEvent:
-			prj.gh.ProccessIssuesEvent(event)
+		action := ""
+		if e, ok := event.(interface{ GetAction() string }); ok {
+			action = e.GetAction()
 		}
-		c.JSON(200, gin.H{
-			"status": "ok",
+		l = l.WithFields(utils.EventFields(event)).WithField("action", action)
+		if !p.recordDelivery(l, deliveryID, eventType, event) {
+			l.Info("Skipping duplicate delivery")
+			utils.WebhookDeliveries.WithLabelValues(eventType, action, "duplicate").Inc()
+			c.JSON(200, gin.H{
+				"status": "duplicate",
+			})
+			return
+		}
+		if err := p.Queue.Enqueue(&utils.Job{DeliveryID: deliveryID, EventType: eventType, Payload: payload}); err != nil {
+			l.WithError(err).Error("Unable to queue delivery")
+			p.forgetDelivery(l, deliveryID)
+			utils.WebhookDeliveries.WithLabelValues(eventType, action, "rejected").Inc()
+			c.JSON(503, gin.H{"error": err.Error()})
+			return
+		}
+		p.recordTransition(l, event)
+		c.JSON(202, gin.H{
+			"status": "queued",
 		})
 	})
-	r.GET("/reports", func(c *gin.Context) {
+	reports := r.Group("/reports", p.requireAuth)
+	reports.GET("", func(c *gin.Context) {
 		//log.Println(string(reports))
-		c.JSON(200, prj.RunReports())
+		window, err := utils.ParseReportWindow(c.Query("since"), c.Query("until"), c.Query("last"), time.Now())
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		period, err := utils.ParseDuration(c.DefaultQuery("period", viper.GetString("report_period")))
+		if err != nil || period <= 0 {
+			c.JSON(400, gin.H{"error": "invalid period"})
+			return
+		}
+		if err := utils.CheckVelocityPeriod(window, period, time.Now()); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		dimensions, err := utils.ParseDimensions(c.Query("by"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		renderer, ok := utils.RendererFor(c.Query("format"), c.GetHeader("Accept"))
+		if !ok {
+			c.JSON(406, gin.H{"error": "unsupported report format"})
+			return
+		}
+		// the scheduled reports answer requests without their own window
+		reports, generatedAt, cached := p.LatestReports()
+		custom := c.Query("since") != "" || c.Query("until") != "" || c.Query("last") != "" || c.Query("period") != "" || len(dimensions) > 0
+		snapshot := c.Query("snapshot") == "true"
+		if !cached || custom || snapshot || c.Query("refresh") == "true" {
+			reports, generatedAt = p.RunReports(window, period, snapshot, dimensions...), time.Now()
+			if c.Query("save") == "true" {
+				p.saveSnapshot(reports)
+			}
+		}
+		c.Header("Content-Type", renderer.ContentType()+"; charset=utf-8")
+		c.Header("X-Report-Generated-At", generatedAt.Format(time.RFC3339))
+		c.Status(200)
+		if err := renderer.Render(c.Writer, reports); err != nil {
+			logrus.WithError(err).Error("Error Rendering Reports")
+		}
 	})
-	err := r.Run() // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
-	if err != nil {
-		log.Println("Error during Run")
+	reports.GET("/burndown", func(c *gin.Context) {
+		start, end, err := chartWindow(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		var burndown *utils.Burndown
+		switch {
+		case c.Query("milestone") != "":
+			burndown = p.newReporter().GetMilestoneBurndown(c.Query("repo"), c.Query("milestone"), start, end)
+		case c.Query("project") != "":
+			project := p.project(c.Query("project"))
+			if project == nil {
+				c.JSON(404, gin.H{"error": "project not found"})
+				return
+			}
+			if burndown, err = p.newReporter().GetBurndown(project, start, end); err != nil {
+				c.JSON(502, gin.H{"error": err.Error()})
+				return
+			}
+		default:
+			c.JSON(400, gin.H{"error": "project or milestone required"})
+			return
+		}
+		if c.Query("format") != "svg" {
+			c.JSON(200, burndown)
+			return
+		}
+		c.Header("Content-Type", "image/svg+xml; charset=utf-8")
+		c.Status(200)
+		if err := utils.RenderBurndownSVG(c.Writer, burndown); err != nil {
+			logrus.WithError(err).Error("Error Rendering Burndown")
+		}
+	})
+	reports.GET("/cfd", func(c *gin.Context) {
+		start, end, err := chartWindow(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		project := p.project(c.Query("project"))
+		if project == nil {
+			c.JSON(404, gin.H{"error": "project not found"})
+			return
+		}
+		cfd, err := p.newReporter().GetCumulativeFlow(project, start, end)
+		if err != nil {
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		if c.Query("format") != "svg" {
+			c.JSON(200, cfd)
+			return
+		}
+		c.Header("Content-Type", "image/svg+xml; charset=utf-8")
+		c.Status(200)
+		if err := utils.RenderCumulativeFlowSVG(c.Writer, cfd); err != nil {
+			logrus.WithError(err).Error("Error Rendering Cumulative Flow")
+		}
+	})
+	reports.GET("/snapshots", func(c *gin.Context) {
+		if p.Store == nil {
+			c.JSON(503, gin.H{"error": "store unavailable"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit <= 0 {
+			c.JSON(400, gin.H{"error": "invalid limit"})
+			return
+		}
+		summaries, err := p.Store.Snapshots(limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, summaries)
+	})
+	reports.GET("/snapshots/:id", func(c *gin.Context) {
+		if p.Store == nil {
+			c.JSON(503, gin.H{"error": "store unavailable"})
+			return
+		}
+		snapshot, err := p.Store.Snapshot(c.Param("id"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if snapshot == nil {
+			c.JSON(404, gin.H{"error": "snapshot not found"})
+			return
+		}
+		c.JSON(200, snapshot)
+	})
+	reports.GET("/diff", func(c *gin.Context) {
+		if p.Store == nil {
+			c.JSON(503, gin.H{"error": "store unavailable"})
+			return
+		}
+		from, err := p.Store.Snapshot(c.DefaultQuery("from", "previous"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		to, err := p.Store.Snapshot(c.DefaultQuery("to", "latest"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if from == nil || to == nil {
+			c.JSON(404, gin.H{"error": "snapshot not found"})
+			return
+		}
+		c.JSON(200, utils.DiffSnapshots(from, to))
+	})
+	r.GET("/audit", p.requireAuth, func(c *gin.Context) {
+		if p.Store == nil {
+			c.JSON(503, gin.H{"error": "store unavailable"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if err != nil || limit <= 0 {
+			c.JSON(400, gin.H{"error": "invalid limit"})
+			return
+		}
+		entries, err := p.Store.Audit(c.Query("kind"), limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, entries)
+	})
+	admin := r.Group("/admin", p.requireAuth)
+	admin.GET("/failed-requests", func(c *gin.Context) {
+		c.JSON(200, p.gh.FailedRequests.List())
+	})
+	admin.GET("/dead-letters", func(c *gin.Context) {
+		if p.Store == nil {
+			c.JSON(503, gin.H{"error": "store unavailable"})
+			return
+		}
+		letters, err := p.Store.DeadLetters()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, letters)
+	})
+	admin.POST("/replay/:delivery", func(c *gin.Context) {
+		if p.Store == nil {
+			c.JSON(503, gin.H{"error": "store unavailable"})
+			return
+		}
+		var letters []utils.DeadLetter
+		if id := c.Param("delivery"); id == "all" {
+			all, err := p.Store.DeadLetters()
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			letters = all
+		} else {
+			d, err := p.Store.DeadLetter(id)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			if d == nil {
+				c.JSON(404, gin.H{"error": "dead letter not found"})
+				return
+			}
+			letters = append(letters, *d)
+		}
+		replayed, err := p.replay(letters)
+		if err != nil {
+			c.JSON(503, gin.H{"error": err.Error(), "replayed": replayed})
+			return
+		}
+		c.JSON(202, gin.H{"replayed": replayed})
+	})
+	admin.GET("/config", func(c *gin.Context) {
+		c.JSON(200, p.effectiveConfig())
+	})
+	admin.GET("/projects", func(c *gin.Context) {
+		projects, err := p.projectColumns()
+		if err != nil {
+			c.JSON(502, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, projects)
+	})
+	admin.GET("/rules/label", func(c *gin.Context) {
+		rules, err := p.RuleProcessor.ListLabelRules()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, rules)
+	})
+	admin.POST("/rules/label", func(c *gin.Context) {
+		var rule utils.LabelRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		persisted, err := p.RuleProcessor.AddLabelRule(rule)
+		if err != nil {
+			labelRuleError(c, err)
+			return
+		}
+		logrus.WithFields(logrus.Fields{"user": c.GetString("user"), "rule": rule.Name}).Info("Label rule added")
+		c.JSON(201, labelRuleChange{LabelRule: rule, Persisted: persisted})
+	})
+	admin.PUT("/rules/label/:name", func(c *gin.Context) {
+		var rule utils.LabelRule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		persisted, err := p.RuleProcessor.UpdateLabelRule(c.Param("name"), rule)
+		if err != nil {
+			labelRuleError(c, err)
+			return
+		}
+		logrus.WithFields(logrus.Fields{"user": c.GetString("user"), "rule": c.Param("name")}).Info("Label rule updated")
+		c.JSON(200, labelRuleChange{LabelRule: rule, Persisted: persisted})
+	})
+	admin.DELETE("/rules/label/:name", func(c *gin.Context) {
+		persisted, err := p.RuleProcessor.DeleteLabelRule(c.Param("name"))
+		if err != nil {
+			labelRuleError(c, err)
+			return
+		}
+		logrus.WithFields(logrus.Fields{"user": c.GetString("user"), "rule": c.Param("name")}).Info("Label rule deleted")
+		c.JSON(200, gin.H{"Persisted": persisted})
+	})
+	return r
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "reconcile-hooks" {
+		os.Exit(reconcileHooks(os.Args[2:]))
+	}
+	prj := NewPRJ()
+	if err := prj.loadConfig(); err != nil {
+		logrus.WithError(err).Error("Unable to load config, readiness will retry")
+	}
+	prj.startupHooks()
+	prj.Queue.Start(viper.GetInt("queue_workers"))
+	prj.scheduleJobs()
+	r := prj.Router()
+	addr := ":8080" // listen and serve on 0.0.0.0:8080 unless PORT is set
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	srv := &http.Server{Addr: addr, Handler: r}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.WithError(err).Error("Error during Run")
+			stop <- syscall.SIGTERM
+		}
+	}()
+	<-stop
+	logrus.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("Error shutting down server")
 	}
+	prj.Close()
 }
@@ -1,18 +1,175 @@
 package main_test
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	projector "github.com/secberus-oss/projector"
+	"github.com/secberus-oss/projector/utils"
+	"github.com/spf13/viper"
 )
 
 var _ = Describe("Projector", func() {
 	Describe("Healthcheck", func() {
 		Context("Server works correctly", func() {
 			It("should return a 200", func() {
+				viper.Set("store_path", filepath.Join(os.TempDir(), "projector_test.db"))
 				prj := projector.NewPRJ()
+				defer prj.Close()
 				Expect(prj.CheckHealth()).To(Equal(200))
 			})
 		})
 	})
+	Describe("Admin API", func() {
+		var (
+			prj *projector.PRJ
+			gh  *httptest.Server
+			dir string
+			at  = time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+		)
+		BeforeEach(func() {
+			gin.SetMode(gin.TestMode)
+			gh = httptest.NewServer(http.NotFoundHandler())
+			var err error
+			dir, err = ioutil.TempDir("", "projector")
+			Expect(err).NotTo(HaveOccurred())
+			viper.Set("github_api_url", gh.URL)
+			viper.Set("store_path", filepath.Join(dir, "test.db"))
+			viper.Set("admin_tokens", "admin")
+			viper.Set("queue_size", 1)
+			prj = projector.NewPRJ()
+		})
+		AfterEach(func() {
+			prj.Close()
+			gh.Close()
+			os.RemoveAll(dir)
+			viper.Set("github_api_url", "")
+			viper.Set("admin_tokens", "")
+			viper.Set("queue_size", 500)
+		})
+		request := func(method string, path string, auth string, body ...string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(method, path, strings.NewReader(strings.Join(body, "")))
+			req.Header.Set("Authorization", auth)
+			prj.Router().ServeHTTP(w, req)
+			return w
+		}
+		replay := func(id string) *httptest.ResponseRecorder {
+			return request("POST", "/admin/replay/"+id, "Bearer admin")
+		}
+		Context("A token without the Bearer scheme", func() {
+			It("should be rejected", func() {
+				Expect(request("GET", "/admin/failed-requests", "admin").Code).To(Equal(401))
+				Expect(request("GET", "/admin/failed-requests", "bearer admin").Code).To(Equal(200))
+			})
+		})
+		Context("A label rule added without a rules config file", func() {
+			It("should not be persisted", func() {
+				// the rules config is looked up in the working directory
+				wd, err := os.Getwd()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.Chdir(dir)).To(Succeed())
+				defer os.Chdir(wd)
+				prj.Close()
+				prj = projector.NewPRJ()
+				rule := `{"Name": "Bugs", "Label": "bug", "Project": "Bugs", "Column": "Triage", "State": "open", "Content": "Issue"}`
+				w := request("POST", "/admin/rules/label", "Bearer admin", rule)
+				Expect(w.Code).To(Equal(201))
+				Expect(w.Body.String()).To(ContainSubstring(`"Name":"Bugs"`))
+				Expect(w.Body.String()).To(ContainSubstring(`"Persisted":false`))
+			})
+		})
+		Context("A report with too many velocity periods", func() {
+			It("should be rejected", func() {
+				Expect(request("GET", "/reports?since=2000-01-01&period=1s", "Bearer admin").Code).To(Equal(400))
+				Expect(request("GET", "/reports?since=2000-01-01&period=1d", "Bearer admin").Code).To(Equal(400))
+			})
+		})
+		Context("A webhook with a bad signature", func() {
+			It("should be unauthorized", func() {
+				viper.Set("hook_secret", "secret")
+				defer viper.Set("hook_secret", "")
+				prj.Close()
+				prj = projector.NewPRJ()
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("POST", "/webhook", strings.NewReader(`{"action":"opened"}`))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-GitHub-Event", "issues")
+				req.Header.Set("X-GitHub-Delivery", "a")
+				req.Header.Set("X-Hub-Signature", "sha1=0000")
+				prj.Router().ServeHTTP(w, req)
+				Expect(w.Code).To(Equal(401))
+				Expect(w.Body.String()).To(ContainSubstring("signature"))
+			})
+		})
+		Context("A delivery that couldn't be queued", func() {
+			It("should not be taken for a duplicate when redelivered", func() {
+				deliver := func(id string) *httptest.ResponseRecorder {
+					w := httptest.NewRecorder()
+					req, _ := http.NewRequest("POST", "/webhook", strings.NewReader(`{"action":"opened"}`))
+					req.Header.Set("Content-Type", "application/json")
+					req.Header.Set("X-GitHub-Event", "issues")
+					req.Header.Set("X-GitHub-Delivery", id)
+					prj.Router().ServeHTTP(w, req)
+					return w
+				}
+				// the queue holds one delivery and isn't started
+				Expect(deliver("a").Code).To(Equal(202))
+				Expect(deliver("b").Code).To(Equal(503))
+				Expect(deliver("b").Code).To(Equal(503))
+				Expect(deliver("a").Code).To(Equal(200))
+			})
+		})
+		Context("The effective config", func() {
+			It("should redact tokens", func() {
+				w := request("GET", "/admin/config", "Bearer admin")
+				Expect(w.Code).To(Equal(200))
+				Expect(w.Body.String()).NotTo(ContainSubstring(`"admin"`))
+				Expect(w.Body.String()).To(ContainSubstring(`"admin_tokens":"REDACTED"`))
+			})
+		})
+		Context("A replayed delivery failing again", func() {
+			It("should be kept", func() {
+				prj.Queue.MaxAttempts = 1
+				prj.Queue.Start(1)
+				Expect(prj.Store.SaveDeadLetter(utils.DeadLetter{DeliveryID: "a", Event: "unknown", Payload: []byte(`{}`), Attempts: 3, FailedAt: at})).To(Succeed())
+				Expect(replay("a").Code).To(Equal(202))
+				attempts := func() int {
+					d, _ := prj.Store.DeadLetter("a")
+					if d == nil {
+						return 0
+					}
+					return d.Attempts
+				}
+				Eventually(attempts).Should(Equal(1))
+			})
+		})
+		Context("Dead letters past the free queue slots", func() {
+			It("should stay stored", func() {
+				Expect(prj.Store.SaveDeadLetter(utils.DeadLetter{DeliveryID: "a", Event: "issues", Payload: []byte(`{}`), FailedAt: at})).To(Succeed())
+				Expect(prj.Store.SaveDeadLetter(utils.DeadLetter{DeliveryID: "b", Event: "issues", Payload: []byte(`{}`), FailedAt: at.Add(time.Hour)})).To(Succeed())
+				w := replay("all")
+				Expect(w.Code).To(Equal(503))
+				var body struct{ Replayed []string }
+				Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+				Expect(body.Replayed).To(Equal([]string{"a"}))
+				Expect(prj.Store.DeadLetter("a")).To(BeNil())
+				Expect(prj.Store.DeadLetter("b")).NotTo(BeNil())
+			})
+		})
+		Context("An unknown delivery", func() {
+			It("should not be found", func() {
+				Expect(replay("missing").Code).To(Equal(404))
+			})
+		})
+	})
 })
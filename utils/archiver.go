@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveRule defines which cards get archived and when
+type ArchiveRule struct {
+	Name    string
+	Project string
+	Columns []string
+	Days    int
+}
+
+// Validate checks an archive rule names its project and columns and waits at
+// least a day, as a missing days would archive every card
+func (a ArchiveRule) Validate() error {
+	switch {
+	case a.Project == "" || len(a.Columns) == 0:
+		return errors.New("project and columns are required")
+	case a.Days < 1:
+		return fmt.Errorf("days must be at least 1, not %d", a.Days)
+	}
+	return nil
+}
+
+// ArchiveRules returns the configured archive rules, leaving out invalid ones
+func (r *RulesProcessor) ArchiveRules() []ArchiveRule {
+	var rules []ArchiveRule
+	if err := r.decodeRules("ArchiveRules", &rules); err != nil {
+		r.log.WithError(err).Error("Error Decoding Archive Rules")
+		return nil
+	}
+	valid := rules[:0]
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			r.log.WithError(err).WithField("rule", rule.Name).Error("Ignoring Invalid Archive Rule")
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid
+}
+
+// ArchiveRun records the cards archived by a single rule run
+type ArchiveRun struct {
+	Rule     string    `json:"Rule"`
+	Project  string    `json:"Project"`
+	Started  time.Time `json:"Started"`
+	Finished time.Time `json:"Finished"`
+	CardIDs  []int64   `json:"CardIDs"`
+}
+
+// ArchiveStaleCards archives cards that have sat in a rule's columns for
+// longer than the rule allows
+func (r *RulesProcessor) ArchiveStaleCards() {
+	for _, rule := range r.ArchiveRules() {
+		run := r.archiveRule(rule, time.Now())
+		r.mu.Lock()
+		r.ArchiveRuns = append(r.ArchiveRuns, run)
+		r.mu.Unlock()
+		if r.store != nil {
+			if err := r.store.SaveArchiveRun(run); err != nil {
+				r.log.WithError(err).WithField("rule", rule.Name).Error("Error Storing Archive Run")
+			}
+		}
+		r.log.WithFields(logrus.Fields{"rule": rule.Name, "cards": len(run.CardIDs)}).Info("Archived Cards")
+	}
+}
+
+// HasArchived reports whether any archive run, including the stored runs of
+// earlier processes, has archived cards
+func (r *RulesProcessor) HasArchived() bool {
+	r.mu.Lock()
+	runs := r.ArchiveRuns
+	r.mu.Unlock()
+	if r.store != nil {
+		stored, err := r.store.ArchiveRuns()
+		if err != nil {
+			r.log.WithError(err).Error("Error Reading Archive Runs")
+		}
+		runs = append(stored, runs...)
+	}
+	for _, run := range runs {
+		if len(run.CardIDs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RulesProcessor) archiveRule(rule ArchiveRule, now time.Time) ArchiveRun {
+	run := ArchiveRun{Rule: rule.Name, Project: rule.Project, Started: now}
+	l := r.log.WithField("rule", rule.Name)
+	projID, err := r.gh.GetProjectID(rule.Project)
+	if err != nil {
+		l.WithError(err).Warn("Unable to find project for archive rule")
+		run.Finished = time.Now()
+		return run
+	}
+	columns, err := r.gh.ListProjectColumns(projID)
+	if err != nil {
+		l.WithError(err).Error("Unable to list columns for archive rule")
+		run.Finished = time.Now()
+		return run
+	}
+	for _, name := range rule.Columns {
+		colID, ok := r.gh.GetCardColumnIDByName(columns, name)
+		if !ok {
+			l.WithField("column", name).Warn("Unable to get Column ID")
+			continue
+		}
+		cards, err := r.gh.ListProjectCards(colID)
+		if err != nil {
+			l.WithError(err).Error("Unable to list cards for archive rule")
+			continue
+		}
+		for _, card := range cards {
+			if !CardIsStale(card, rule.Days, now) {
+				continue
+			}
+			matchedRule("archive", rule.Name)
+			if err := r.gh.ArchiveProjectCard(*card.ID); err != nil {
+				l.WithError(err).WithField("card", *card.ID).Error("Error Archiving Card")
+				continue
+			}
+			run.CardIDs = append(run.CardIDs, *card.ID)
+		}
+	}
+	run.Finished = time.Now()
+	return run
+}
+
+// CardIsStale checks if a card has not been touched for more than days.
+// GitHub bumps a card's updated_at whenever it moves columns.
+func CardIsStale(card *github.ProjectCard, days int, now time.Time) bool {
+	if card.UpdatedAt == nil {
+		return false
+	}
+	return now.Sub(card.UpdatedAt.Time) > time.Duration(days)*24*time.Hour
+}
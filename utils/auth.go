@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+)
+
+// memberCacheTTL is how long the org membership of a GitHub token is trusted
+const memberCacheTTL = 5 * time.Minute
+
+// ErrUnauthorized rejects requests without a valid token
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator checks the bearer tokens of admin and report requests against
+// the configured admin tokens or, with GitHub auth on, the org membership of
+// the GitHub user owning the token
+type Authenticator struct {
+	tokens     []string
+	githubAuth bool
+	gh         *GH
+	mu         sync.Mutex
+	// members caches GitHub token checks by token hash
+	members map[[sha256.Size]byte]member
+}
+
+type member struct {
+	login   string
+	ok      bool
+	expires time.Time
+}
+
+// NewAuthenticator creates an Authenticator from the admin_tokens (comma
+// separated) and admin_github_auth settings
+func NewAuthenticator(gh *GH) *Authenticator {
+	a := Authenticator{
+		githubAuth: viper.GetBool("admin_github_auth"),
+		gh:         gh,
+		members:    map[[sha256.Size]byte]member{},
+	}
+	for _, token := range strings.Split(viper.GetString("admin_tokens"), ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			a.tokens = append(a.tokens, token)
+		}
+	}
+	return &a
+}
+
+// Enabled checks if any way to authenticate is configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.tokens) > 0 || a.githubAuth
+}
+
+// Authenticate returns who a token belongs to, "admin" for admin tokens or the
+// GitHub login of an org member
+func (a *Authenticator) Authenticate(token string) (string, error) {
+	if token == "" {
+		return "", ErrUnauthorized
+	}
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return "admin", nil
+		}
+	}
+	if !a.githubAuth {
+		return "", ErrUnauthorized
+	}
+	key := sha256.Sum256([]byte(token))
+	a.mu.Lock()
+	m, cached := a.members[key]
+	a.mu.Unlock()
+	if !cached || time.Now().After(m.expires) {
+		login, err := a.gh.TokenLogin(token)
+		if err != nil {
+			a.gh.log.WithError(err).Debug("Unable to get the user of a token")
+			return "", ErrUnauthorized
+		}
+		ok, err := a.gh.IsOrgMember(login)
+		if err != nil {
+			return "", err
+		}
+		m = member{login: login, ok: ok, expires: time.Now().Add(memberCacheTTL)}
+		a.mu.Lock()
+		a.members[key] = m
+		a.mu.Unlock()
+	}
+	if !m.ok {
+		return "", ErrUnauthorized
+	}
+	return m.login, nil
+}
+
+// TokenLogin returns the login of the GitHub user owning a token
+func (g *GH) TokenLogin(token string) (string, error) {
+	ctx := context.Background()
+	c := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})))
+	c.BaseURL = g.c.BaseURL
+	u, _, err := c.Users.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return u.GetLogin(), nil
+}
+
+// IsOrgMember checks if a GitHub user is a member of the org
+func (g *GH) IsOrgMember(login string) (bool, error) {
+	ok, _, err := g.c.Organizations.IsMember(context.Background(), g.org, login)
+	return ok, err
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Breakdown dimensions
+const (
+	ByAssignee   = "assignee"
+	ByAuthor     = "author"
+	ByRepository = "repository"
+	ByMilestone  = "milestone"
+)
+
+// Breakdown groups the items of a report along a dimension
+type Breakdown struct {
+	Dimension string            `json:"Dimension"`
+	Groups    []*BreakdownGroup `json:"Groups"`
+}
+
+// BreakdownGroup shows the closed items, open WIP and labels of one value of
+// a dimension
+type BreakdownGroup struct {
+	Key         string        `json:"Key"`
+	Closed      int           `json:"Closed"`
+	WIP         int           `json:"WIP"`
+	LabelCounts []*LabelCount `json:"LabelCounts,omitempty"`
+}
+
+// ParseDimensions parses a comma separated list of breakdown dimensions
+func ParseDimensions(s string) ([]string, error) {
+	aliases := map[string]string{
+		ByAssignee: ByAssignee, ByAuthor: ByAuthor, ByRepository: ByRepository, ByMilestone: ByMilestone,
+		"repo": ByRepository,
+	}
+	var dimensions []string
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimSpace(strings.ToLower(d))
+		if d == "" {
+			continue
+		}
+		dimension, ok := aliases[d]
+		if !ok {
+			return nil, fmt.Errorf("unknown breakdown %q", d)
+		}
+		dimensions = append(dimensions, dimension)
+	}
+	return dimensions, nil
+}
+
+// cardKeys returns the values of a dimension for a card
+func cardKeys(dimension string, c *Card) []string {
+	switch dimension {
+	case ByAssignee:
+		if len(c.Assignees) == 0 {
+			return []string{"unassigned"}
+		}
+		return c.Assignees
+	case ByAuthor:
+		return []string{orDefault(c.Author, "unknown")}
+	case ByRepository:
+		return []string{orDefault(c.Repo, "none")}
+	case ByMilestone:
+		return []string{orDefault(c.Milestone, "none")}
+	}
+	return nil
+}
+
+// BreakdownCards groups closed and open cards along each dimension
+func (r *Reporter) BreakdownCards(dimensions []string, closed []*Card, open []*Card) []*Breakdown {
+	var breakdowns []*Breakdown
+	for _, d := range dimensions {
+		groups := map[string]*BreakdownGroup{}
+		group := func(key string) *BreakdownGroup {
+			if groups[key] == nil {
+				groups[key] = &BreakdownGroup{Key: key}
+			}
+			return groups[key]
+		}
+		for _, c := range closed {
+			for _, key := range cardKeys(d, c) {
+				g := group(key)
+				g.Closed++
+				for _, l := range c.Labels {
+					g.LabelCounts = r.AppendIfMissing(g.LabelCounts, &LabelCount{Name: l.Name, Count: 1})
+				}
+			}
+		}
+		for _, c := range open {
+			for _, key := range cardKeys(d, c) {
+				group(key).WIP++
+			}
+		}
+		b := &Breakdown{Dimension: d}
+		for _, g := range groups {
+			b.Groups = append(b.Groups, g)
+		}
+		sort.Slice(b.Groups, func(i, j int) bool { return b.Groups[i].Key < b.Groups[j].Key })
+		breakdowns = append(breakdowns, b)
+	}
+	return breakdowns
+}
+
+func orDefault(s string, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
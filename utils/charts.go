@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+)
+
+// dateFormat is the day format of chart series
+const dateFormat = "2006-01-02"
+
+// BurndownPoint counts the open and closed items at the end of a day
+type BurndownPoint struct {
+	Date   string `json:"Date"`
+	Open   int    `json:"Open"`
+	Closed int    `json:"Closed"`
+}
+
+// Burndown is the daily series of open and closed items of a project or milestone
+type Burndown struct {
+	Name   string           `json:"Name"`
+	Series []*BurndownPoint `json:"Series"`
+}
+
+// FlowPoint counts the cards in each column at the end of a day
+type FlowPoint struct {
+	Date    string         `json:"Date"`
+	Columns map[string]int `json:"Columns"`
+}
+
+// CumulativeFlow is the daily series of cards per column of a project
+type CumulativeFlow struct {
+	Name    string       `json:"Name"`
+	Columns []string     `json:"Columns"`
+	Series  []*FlowPoint `json:"Series"`
+}
+
+// days returns the end of every day from start to end
+func days(start time.Time, end time.Time) []time.Time {
+	var ends []time.Time
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+		ends = append(ends, day.AddDate(0, 0, 1).Add(-time.Nanosecond))
+	}
+	return ends
+}
+
+// BurndownSeries counts the items open and closed at the end of every day
+func BurndownSeries(issues []*github.Issue, start time.Time, end time.Time) []*BurndownPoint {
+	series := []*BurndownPoint{}
+	for _, day := range days(start, end) {
+		p := &BurndownPoint{Date: day.Format(dateFormat)}
+		for _, i := range issues {
+			if i.CreatedAt == nil || i.CreatedAt.After(day) {
+				continue
+			}
+			if i.ClosedAt != nil && !i.ClosedAt.After(day) {
+				p.Closed++
+			} else {
+				p.Open++
+			}
+		}
+		series = append(series, p)
+	}
+	return series
+}
+
+// CumulativeFlowSeries counts the cards in each column at the end of every
+// day from their events
+func CumulativeFlowSeries(events [][]CardEvent, columns []string, start time.Time, end time.Time) []*FlowPoint {
+	series := []*FlowPoint{}
+	for _, day := range days(start, end) {
+		p := &FlowPoint{Date: day.Format(dateFormat), Columns: map[string]int{}}
+		for _, col := range columns {
+			p.Columns[col] = 0
+		}
+		for _, cardEvents := range events {
+			column := ""
+			for _, e := range cardEvents {
+				if e.At.After(day) {
+					break
+				}
+				column = e.Column
+				if e.Action == "deleted" {
+					column = ""
+				}
+			}
+			if column != "" {
+				p.Columns[column]++
+			}
+		}
+		series = append(series, p)
+	}
+	return series
+}
+
+// GetBurndown builds the burndown of every Issue and PR on a project board
+func (r *Reporter) GetBurndown(project *github.Project, start time.Time, end time.Time) (*Burndown, error) {
+	columns, err := r.GH.ListProjectColumns(*project.ID)
+	if err != nil {
+		return nil, err
+	}
+	var issues []*github.Issue
+	for _, col := range columns {
+		cards, err := r.GH.ListProjectCardsByState(col.GetID(), "all")
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cards {
+			if c.ContentURL == nil {
+				continue
+			}
+			repo, _, number, err := r.StripContentURL(*c.ContentURL)
+			if err != nil {
+				continue
+			}
+			if i := r.lookupIssue(&Card{Repo: *repo, Number: *number}); i != nil {
+				issues = append(issues, i)
+			}
+		}
+	}
+	return &Burndown{Name: project.GetName(), Series: BurndownSeries(issues, start, end)}, nil
+}
+
+// GetMilestoneBurndown builds the burndown of a milestone, across every repo
+// of the org with a milestone of that title when repo is empty
+func (r *Reporter) GetMilestoneBurndown(repo string, title string, start time.Time, end time.Time) *Burndown {
+	repos := []string{repo}
+	if repo == "" {
+		repos = nil
+		for _, rp := range r.GH.ListRepos() {
+			repos = append(repos, rp.GetName())
+		}
+	}
+	var issues []*github.Issue
+	for _, rp := range repos {
+		issues = append(issues, r.GH.ListMilestoneIssues(rp, title)...)
+	}
+	return &Burndown{Name: title, Series: BurndownSeries(issues, start, end)}
+}
+
+// GetCumulativeFlow builds the cumulative flow of a project from the card
+// history, falling back to Issue timelines
+func (r *Reporter) GetCumulativeFlow(project *github.Project, start time.Time, end time.Time) (*CumulativeFlow, error) {
+	columns, err := r.GH.ListProjectColumns(*project.ID)
+	if err != nil {
+		return nil, err
+	}
+	cfd := &CumulativeFlow{Name: project.GetName()}
+	names := map[int64]string{}
+	var events [][]CardEvent
+	for _, col := range columns {
+		names[col.GetID()] = col.GetName()
+		cfd.Columns = append(cfd.Columns, col.GetName())
+	}
+	for _, col := range columns {
+		// archived cards still count in the days before they were archived
+		cards, err := r.GH.ListProjectCardsByState(col.GetID(), "all")
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cards {
+			cardEvents := r.History.Events(c.GetID())
+			if len(cardEvents) == 0 && c.ContentURL != nil {
+				if repo, number, ok := ParseContentURL(*c.ContentURL); ok {
+					cardEvents = TimelineCardEvents(r.GH.ListIssueTimeline(repo, number), c.GetID())
+					r.History.Add(cardEvents...)
+				}
+			}
+			if len(cardEvents) == 0 && c.CreatedAt != nil {
+				// without any history the card has always been where it is now
+				cardEvents = []CardEvent{{CardID: c.GetID(), Action: "created", Column: col.GetName(), At: c.CreatedAt.Time}}
+			}
+			for i := range cardEvents {
+				if cardEvents[i].Column == "" {
+					cardEvents[i].Column = names[cardEvents[i].ColumnID]
+				}
+			}
+			events = append(events, cardEvents)
+		}
+	}
+	cfd.Series = CumulativeFlowSeries(events, cfd.Columns, start, end)
+	return cfd, nil
+}
+
+// svg chart dimensions
+const (
+	svgWidth  = 800
+	svgHeight = 400
+	svgMargin = 40
+)
+
+var svgColors = []string{"#0366d6", "#28a745", "#d73a49", "#6f42c1", "#f66a0a", "#ffd33d", "#959da5"}
+
+// RenderBurndownSVG draws open and closed items as lines
+func RenderBurndownSVG(w io.Writer, b *Burndown) error {
+	max := 1
+	for _, p := range b.Series {
+		if p.Open+p.Closed > max {
+			max = p.Open + p.Closed
+		}
+	}
+	open := make([]float64, len(b.Series))
+	closed := make([]float64, len(b.Series))
+	for i, p := range b.Series {
+		open[i], closed[i] = float64(p.Open), float64(p.Closed)
+	}
+	var s strings.Builder
+	svgHeader(&s, b.Name, max)
+	fmt.Fprintf(&s, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`+"\n", svgColors[0], svgPoints(open, max))
+	fmt.Fprintf(&s, `<polyline fill="none" stroke="%s" stroke-width="2" points="%s"/>`+"\n", svgColors[1], svgPoints(closed, max))
+	svgLegend(&s, []string{"Open", "Closed"})
+	s.WriteString("</svg>\n")
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+// RenderCumulativeFlowSVG draws the cards per column as stacked areas, the
+// last column at the bottom
+func RenderCumulativeFlowSVG(w io.Writer, cfd *CumulativeFlow) error {
+	max := 1
+	for _, p := range cfd.Series {
+		total := 0
+		for _, n := range p.Columns {
+			total += n
+		}
+		if total > max {
+			max = total
+		}
+	}
+	var s strings.Builder
+	svgHeader(&s, cfd.Name, max)
+	lower := make([]float64, len(cfd.Series))
+	for c := len(cfd.Columns) - 1; c >= 0; c-- {
+		upper := make([]float64, len(cfd.Series))
+		for i, p := range cfd.Series {
+			upper[i] = lower[i] + float64(p.Columns[cfd.Columns[c]])
+		}
+		reversed := make([]float64, len(lower))
+		for i := range lower {
+			reversed[i] = lower[len(lower)-1-i]
+		}
+		points := svgPoints(upper, max) + " " + svgPointsFrom(reversed, max, len(lower)-1, -1)
+		fmt.Fprintf(&s, `<polygon fill="%s" fill-opacity="0.8" points="%s"/>`+"\n", svgColors[c%len(svgColors)], points)
+		lower = upper
+	}
+	svgLegend(&s, cfd.Columns)
+	s.WriteString("</svg>\n")
+	_, err := io.WriteString(w, s.String())
+	return err
+}
+
+func svgHeader(s *strings.Builder, title string, max int) {
+	fmt.Fprintf(s, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", svgWidth, svgHeight)
+	fmt.Fprintf(s, `<text x="%d" y="20" font-size="16">%s</text>`+"\n", svgMargin, escapeSVG(title))
+	fmt.Fprintf(s, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#444"/>`+"\n", svgMargin, svgHeight-svgMargin, svgWidth-svgMargin, svgHeight-svgMargin)
+	fmt.Fprintf(s, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#444"/>`+"\n", svgMargin, svgMargin, svgMargin, svgHeight-svgMargin)
+	fmt.Fprintf(s, `<text x="%d" y="%d" text-anchor="end">%d</text>`+"\n", svgMargin-4, svgMargin+4, max)
+}
+
+func svgLegend(s *strings.Builder, names []string) {
+	for i, name := range names {
+		y := svgMargin + 16*i
+		fmt.Fprintf(s, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`+"\n", svgWidth-svgMargin-120, y, svgColors[i%len(svgColors)])
+		fmt.Fprintf(s, `<text x="%d" y="%d">%s</text>`+"\n", svgWidth-svgMargin-104, y+9, escapeSVG(name))
+	}
+}
+
+// svgPoints maps values spread over the width of the chart to coordinates
+func svgPoints(values []float64, max int) string {
+	return svgPointsFrom(values, max, 0, 1)
+}
+
+// svgPointsFrom maps values to coordinates starting at index first and
+// moving step indices along the x axis per value
+func svgPointsFrom(values []float64, max int, first int, step int) string {
+	plotWidth := float64(svgWidth - 2*svgMargin)
+	plotHeight := float64(svgHeight - 2*svgMargin)
+	spread := float64(len(values) - 1)
+	if spread < 1 {
+		spread = 1
+	}
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := float64(svgMargin) + float64(first+i*step)/spread*plotWidth
+		y := float64(svgHeight-svgMargin) - v/float64(max)*plotHeight
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
+
+func escapeSVG(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
+)
+
+// CommandPrefix starts every projector command in a comment
+const CommandPrefix = "/projector"
+
+// Command is a projector command parsed from a comment
+type Command struct {
+	Name string
+	Args []string
+}
+
+// ParseCommand finds the first projector command in a comment body
+func ParseCommand(body string) (*Command, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		fields := splitArgs(strings.TrimSpace(line))
+		if len(fields) < 2 || fields[0] != CommandPrefix {
+			continue
+		}
+		return &Command{Name: fields[1], Args: fields[2:]}, true
+	}
+	return nil, false
+}
+
+// splitArgs splits on spaces, keeping double quoted arguments together
+func splitArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	quoted, started := false, false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			started = true
+		case c == ' ' && !quoted:
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(c)
+			started = true
+		}
+	}
+	if started {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// ProcessIssueCommentEvent runs projector commands found in new comments.
+// Failed commands are answered on the Issue or PR, only failing to answer is
+// returned as an error.
+func (r *RulesProcessor) ProcessIssueCommentEvent(e *github.IssueCommentEvent) error {
+	if e.GetAction() != "created" {
+		return nil
+	}
+	cmd, ok := ParseCommand(e.GetComment().GetBody())
+	if !ok {
+		return nil
+	}
+	repo := e.GetRepo().GetName()
+	user := e.GetComment().GetUser().GetLogin()
+	r.log.WithFields(logrus.Fields{"command": cmd.Name, "args": cmd.Args, "user": user}).Info("Received command")
+	err := r.authorizeCommand(repo, user)
+	if err == nil {
+		err = r.RunCommand(cmd, e.GetIssue(), repo)
+	}
+	if err != nil {
+		r.log.WithError(err).WithField("command", cmd.Name).Warn("Command failed")
+		if rErr := r.gh.CreateCommentReaction(repo, e.GetComment().GetID(), "confused"); rErr != nil {
+			r.log.WithError(rErr).Error("Error Reacting to Comment")
+		}
+		body := fmt.Sprintf("@%s `%s %s` failed: %s", user, CommandPrefix, cmd.Name, err)
+		if cErr := r.gh.CreateComment(repo, e.GetIssue().GetNumber(), body); cErr != nil {
+			return fmt.Errorf("commenting command result: %w", cErr)
+		}
+		return nil
+	}
+	if rErr := r.gh.CreateCommentReaction(repo, e.GetComment().GetID(), "+1"); rErr != nil {
+		return fmt.Errorf("reacting to comment: %w", rErr)
+	}
+	return nil
+}
+
+// authorizeCommand makes sure the commenter can write to the repo
+func (r *RulesProcessor) authorizeCommand(repo string, user string) error {
+	permission, err := r.gh.GetPermissionLevel(repo, user)
+	if err != nil {
+		return err
+	}
+	if permission != "admin" && permission != "write" {
+		return fmt.Errorf("%s needs write access to %s", user, repo)
+	}
+	return nil
+}
+
+// RunCommand runs a projector command against an Issue or PR.
+//
+//	move <column> [project]    moves the card, defaults to the default project
+//	project <project> [column] adds a card, defaults to the first column
+//	remove [project]           removes the card, defaults to the default project
+func (r *RulesProcessor) RunCommand(cmd *Command, issue *github.Issue, repo string) error {
+	content, contentID, err := r.content(issue, repo)
+	if err != nil {
+		return err
+	}
+	switch cmd.Name {
+	case "move":
+		if len(cmd.Args) == 0 {
+			return errors.New("usage: move <column> [project]")
+		}
+		project := argOr(cmd.Args, 1, r.gh.DefaultProjectName)
+		projID, err := r.gh.GetProjectID(project)
+		if err != nil {
+			return err
+		}
+		card, err := r.gh.GetProjectCardByIssue(*issue, repo, projID)
+		if err != nil {
+			return err
+		}
+		rule := LabelRule{Name: "command", Project: project, Column: cmd.Args[0], Content: content}
+		return r.placeCard(rule, contentID, card)
+	case "project":
+		if len(cmd.Args) == 0 {
+			return errors.New("usage: project <project> [column]")
+		}
+		projID, err := r.gh.GetProjectID(cmd.Args[0])
+		if err != nil {
+			return err
+		}
+		column := argOr(cmd.Args, 1, "")
+		if column == "" {
+			columns, err := r.gh.ListProjectColumns(projID)
+			if err != nil {
+				return err
+			}
+			if len(columns) == 0 {
+				return fmt.Errorf("project %q has no columns", cmd.Args[0])
+			}
+			column = columns[0].GetName()
+		}
+		rule := LabelRule{Name: "command", Project: cmd.Args[0], Column: column, Content: content}
+		return r.placeCard(rule, contentID, nil)
+	case "remove":
+		project := argOr(cmd.Args, 0, r.gh.DefaultProjectName)
+		projID, err := r.gh.GetProjectID(project)
+		if err != nil {
+			return err
+		}
+		card, err := r.gh.GetProjectCardByIssue(*issue, repo, projID)
+		if err != nil {
+			return err
+		}
+		if card == nil {
+			return fmt.Errorf("no card in project %q", project)
+		}
+		return r.gh.DeleteProjectCard(*card.ID)
+	}
+	return fmt.Errorf("unknown command %q", cmd.Name)
+}
+
+// content returns the card content type and ID of an Issue or PR
+func (r *RulesProcessor) content(issue *github.Issue, repo string) (string, int64, error) {
+	if !issue.IsPullRequest() {
+		return "Issue", issue.GetID(), nil
+	}
+	pr, _ := r.gh.GetPR(repo, issue.GetNumber())
+	if pr == nil {
+		return "", 0, fmt.Errorf("unable to get pull request #%d", issue.GetNumber())
+	}
+	return "PullRequest", pr.GetID(), nil
+}
+
+func argOr(args []string, i int, fallback string) string {
+	if len(args) > i {
+		return args[i]
+	}
+	return fallback
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ReportSnapshot is a dated set of generated reports
+type ReportSnapshot struct {
+	ID        string    `json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	Reports   []Report  `json:"Reports"`
+}
+
+// SnapshotSummary describes a snapshot without its reports
+type SnapshotSummary struct {
+	ID        string     `json:"ID"`
+	CreatedAt time.Time  `json:"CreatedAt"`
+	Since     *time.Time `json:"Since,omitempty"`
+	Until     *time.Time `json:"Until,omitempty"`
+	Projects  []string   `json:"Projects"`
+}
+
+// SnapshotDiff compares the reports of two snapshots
+type SnapshotDiff struct {
+	From     *SnapshotSummary `json:"From"`
+	To       *SnapshotSummary `json:"To"`
+	Projects []*ProjectDiff   `json:"Projects"`
+}
+
+// ProjectDiff shows how a project changed between two snapshots
+type ProjectDiff struct {
+	ProjectBoard string         `json:"ProjectBoard"`
+	Columns      []*ColumnDiff  `json:"Columns"`
+	LabelDeltas  map[string]int `json:"LabelDeltas"`
+	Throughput   Throughput     `json:"Throughput"`
+}
+
+// ColumnDiff lists the items added to and removed from a column
+type ColumnDiff struct {
+	Column  string   `json:"Column"`
+	Added   []string `json:"Added"`
+	Removed []string `json:"Removed"`
+}
+
+// Throughput compares the items closed in two snapshots
+type Throughput struct {
+	From    int      `json:"From"`
+	To      int      `json:"To"`
+	Delta   int      `json:"Delta"`
+	Percent *float64 `json:"Percent,omitempty"`
+}
+
+// doneColumn names the done cards of reports generated without a snapshot
+const doneColumn = "done"
+
+// Summary describes the snapshot without its reports
+func (s *ReportSnapshot) Summary() *SnapshotSummary {
+	summary := &SnapshotSummary{ID: s.ID, CreatedAt: s.CreatedAt, Projects: []string{}}
+	for _, r := range s.Reports {
+		summary.Projects = append(summary.Projects, r.ProjectBoard)
+		summary.Since, summary.Until = r.Since, r.Until
+	}
+	return summary
+}
+
+// DiffSnapshots compares every project found in either snapshot
+func DiffSnapshots(from *ReportSnapshot, to *ReportSnapshot) *SnapshotDiff {
+	diff := &SnapshotDiff{From: from.Summary(), To: to.Summary(), Projects: []*ProjectDiff{}}
+	fromReports, toReports := map[string]Report{}, map[string]Report{}
+	var names []string
+	for _, r := range from.Reports {
+		fromReports[r.ProjectBoard] = r
+		names = append(names, r.ProjectBoard)
+	}
+	for _, r := range to.Reports {
+		if _, ok := fromReports[r.ProjectBoard]; !ok {
+			names = append(names, r.ProjectBoard)
+		}
+		toReports[r.ProjectBoard] = r
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		diff.Projects = append(diff.Projects, DiffReports(fromReports[name], toReports[name]))
+	}
+	return diff
+}
+
+// DiffReports compares two reports of the same project
+func DiffReports(from Report, to Report) *ProjectDiff {
+	name := to.ProjectBoard
+	if name == "" {
+		name = from.ProjectBoard
+	}
+	d := &ProjectDiff{ProjectBoard: name, Columns: []*ColumnDiff{}, LabelDeltas: map[string]int{}}
+	fromItems, toItems := reportItems(from), reportItems(to)
+	var columns []string
+	for col := range fromItems {
+		columns = append(columns, col)
+	}
+	for col := range toItems {
+		if _, ok := fromItems[col]; !ok {
+			columns = append(columns, col)
+		}
+	}
+	sort.Strings(columns)
+	for _, col := range columns {
+		cd := &ColumnDiff{Column: col, Added: []string{}, Removed: []string{}}
+		for item := range toItems[col] {
+			if !fromItems[col][item] {
+				cd.Added = append(cd.Added, item)
+			}
+		}
+		for item := range fromItems[col] {
+			if !toItems[col][item] {
+				cd.Removed = append(cd.Removed, item)
+			}
+		}
+		sort.Strings(cd.Added)
+		sort.Strings(cd.Removed)
+		d.Columns = append(d.Columns, cd)
+	}
+	for _, lc := range from.LabelCounts {
+		d.LabelDeltas[*lc.Name] -= lc.Count
+	}
+	for _, lc := range to.LabelCounts {
+		d.LabelDeltas[*lc.Name] += lc.Count
+	}
+	for label, delta := range d.LabelDeltas {
+		if delta == 0 {
+			delete(d.LabelDeltas, label)
+		}
+	}
+	d.Throughput = Throughput{From: from.IssuesClosed, To: to.IssuesClosed, Delta: to.IssuesClosed - from.IssuesClosed}
+	if from.IssuesClosed > 0 {
+		percent := math.Round(float64(d.Throughput.Delta)/float64(from.IssuesClosed)*1000) / 10
+		d.Throughput.Percent = &percent
+	}
+	return d
+}
+
+// reportItems returns the items of each column of a report. Reports without
+// a board snapshot only know their done cards.
+func reportItems(r Report) map[string]map[string]bool {
+	items := map[string]map[string]bool{}
+	add := func(column string, cards []*Card) {
+		if items[column] == nil {
+			items[column] = map[string]bool{}
+		}
+		for _, c := range cards {
+			items[column][itemKey(c)] = true
+		}
+	}
+	if len(r.Columns) > 0 {
+		for _, col := range r.Columns {
+			add(col.Column, col.Cards)
+		}
+	} else if r.ProjectCards != nil {
+		add(doneColumn, r.ProjectCards)
+	}
+	return items
+}
+
+// itemKey identifies the Issue, PR or note of a card
+func itemKey(c *Card) string {
+	if c.Repo != "" {
+		return fmt.Sprintf("%s#%d", c.Repo, c.Number)
+	}
+	return fmt.Sprintf("card:%d", c.GetID())
+}
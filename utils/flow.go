@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Percentiles summarizes durations in hours
+type Percentiles struct {
+	Count int     `json:"Count"`
+	P50   float64 `json:"P50Hours"`
+	P85   float64 `json:"P85Hours"`
+	P95   float64 `json:"P95Hours"`
+	Max   float64 `json:"MaxHours"`
+}
+
+// FlowMetrics shows how long items take to get done
+type FlowMetrics struct {
+	LeadTime     Percentiles            `json:"LeadTime"`
+	CycleTime    Percentiles            `json:"CycleTime"`
+	TimeInColumn map[string]Percentiles `json:"TimeInColumn"`
+	Labels       map[string]*LabelFlow  `json:"Labels,omitempty"`
+}
+
+// LabelFlow shows lead, cycle and time in column of the items with a label
+type LabelFlow struct {
+	LeadTime     Percentiles            `json:"LeadTime"`
+	CycleTime    Percentiles            `json:"CycleTime"`
+	TimeInColumn map[string]Percentiles `json:"TimeInColumn"`
+}
+
+// CardFlow holds the flow durations of a single card. Zero lead or cycle
+// times mean they could not be computed.
+type CardFlow struct {
+	LeadTime  time.Duration
+	CycleTime time.Duration
+	InColumn  map[string]time.Duration
+}
+
+// ComputeCardFlow reconstructs the time a card spent in each column from its
+// events. Lead time runs from opening to closing the item, cycle time from
+// first entering cycleStart (or the first move when it never did) to closing.
+func ComputeCardFlow(events []CardEvent, columns map[int64]string, opened *time.Time, closed *time.Time, cycleStart string) CardFlow {
+	flow := CardFlow{InColumn: map[string]time.Duration{}}
+	var end time.Time
+	switch {
+	case closed != nil:
+		end = *closed
+	case len(events) > 0:
+		end = events[len(events)-1].At
+	}
+	if opened != nil && closed != nil && closed.After(*opened) {
+		flow.LeadTime = closed.Sub(*opened)
+	}
+	var start *time.Time
+	for i, e := range events {
+		name := e.Column
+		if name == "" {
+			name = columns[e.ColumnID]
+		}
+		if start == nil && name == cycleStart && cycleStart != "" {
+			start = &events[i].At
+		}
+		if e.Action == "deleted" || i+1 == len(events) || name == "" {
+			continue
+		}
+		flow.InColumn[name] += events[i+1].At.Sub(e.At)
+	}
+	if start == nil && len(events) > 1 {
+		start = &events[1].At
+	}
+	if start != nil && end.After(*start) {
+		flow.CycleTime = end.Sub(*start)
+	}
+	return flow
+}
+
+// SummarizeFlows aggregates card flows per project, column and label
+func SummarizeFlows(cards []*Card, flows []CardFlow) *FlowMetrics {
+	var lead, cycle []time.Duration
+	inColumn := map[string][]time.Duration{}
+	labelLead := map[string][]time.Duration{}
+	labelCycle := map[string][]time.Duration{}
+	labelInColumn := map[string]map[string][]time.Duration{}
+	for i, f := range flows {
+		if f.LeadTime > 0 {
+			lead = append(lead, f.LeadTime)
+		}
+		if f.CycleTime > 0 {
+			cycle = append(cycle, f.CycleTime)
+		}
+		for col, d := range f.InColumn {
+			inColumn[col] = append(inColumn[col], d)
+		}
+		for _, l := range cards[i].Labels {
+			if f.LeadTime > 0 {
+				labelLead[l.GetName()] = append(labelLead[l.GetName()], f.LeadTime)
+			}
+			if f.CycleTime > 0 {
+				labelCycle[l.GetName()] = append(labelCycle[l.GetName()], f.CycleTime)
+			}
+			if labelInColumn[l.GetName()] == nil {
+				labelInColumn[l.GetName()] = map[string][]time.Duration{}
+			}
+			for col, d := range f.InColumn {
+				labelInColumn[l.GetName()][col] = append(labelInColumn[l.GetName()][col], d)
+			}
+		}
+	}
+	m := &FlowMetrics{
+		LeadTime:     NewPercentiles(lead),
+		CycleTime:    NewPercentiles(cycle),
+		TimeInColumn: map[string]Percentiles{},
+		Labels:       map[string]*LabelFlow{},
+	}
+	for col, ds := range inColumn {
+		m.TimeInColumn[col] = NewPercentiles(ds)
+	}
+	for _, c := range cards {
+		for _, l := range c.Labels {
+			label := &LabelFlow{
+				LeadTime:     NewPercentiles(labelLead[l.GetName()]),
+				CycleTime:    NewPercentiles(labelCycle[l.GetName()]),
+				TimeInColumn: map[string]Percentiles{},
+			}
+			for col, ds := range labelInColumn[l.GetName()] {
+				label.TimeInColumn[col] = NewPercentiles(ds)
+			}
+			m.Labels[l.GetName()] = label
+		}
+	}
+	return m
+}
+
+// NewPercentiles computes nearest rank percentiles of durations
+func NewPercentiles(ds []time.Duration) Percentiles {
+	p := Percentiles{Count: len(ds)}
+	if len(ds) == 0 {
+		return p
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(q float64) float64 {
+		i := int(math.Ceil(q*float64(len(sorted)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return math.Round(sorted[i].Hours()*100) / 100
+	}
+	p.P50, p.P85, p.P95, p.Max = rank(0.50), rank(0.85), rank(0.95), rank(1)
+	return p
+}
@@ -2,11 +2,17 @@ package utils
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
 )
@@ -14,152 +20,178 @@ import (
 // GH encapsulates github client & metadata
 type GH struct {
 	c                  *github.Client
+	log                *logrus.Entry
 	org                string
 	DefaultProjectName string
 	DefaultProjectID   int64
 	hookURL            string
-	Secret             []byte
-	defaultColumnID    int64
-	defaultColumnName  string
-	repos              []*github.Repository
-	Projects           []*github.Project
-	defaultColumns     []*github.ProjectColumn
+	// hookRepos are the repos to install hooks on instead of the org
+	hookRepos []string
+	Secret    []byte
+	// PreviousSecret is still accepted while the hook secret is rotated
+	PreviousSecret    []byte
+	defaultColumnID   int64
+	defaultColumnName string
+	Projects          []*github.Project
+	defaultColumns    []*github.ProjectColumn
+	// FailedRequests lists the GitHub requests that failed for good
+	FailedRequests *FailedRequests
+	// mu guards Projects and the default project and column, which are
+	// reloaded while copies are made for deliveries
+	mu *sync.RWMutex
 }
 
 // NewGH creates a new instance of GH
 func NewGH() *GH {
+	failed := &FailedRequests{}
 	gh := GH{
-		c:                  initClient(),
+		c:                  initClient(failed),
+		log:                newLog(),
 		org:                viper.GetString("org_name"),
 		hookURL:            viper.GetString("hook_url"),
+		hookRepos:          hookRepos(),
 		Secret:             []byte(viper.GetString("hook_secret")),
+		PreviousSecret:     []byte(viper.GetString("hook_previous_secret")),
 		defaultColumnName:  viper.GetString("default_column"),
 		DefaultProjectName: viper.GetString("default_project"),
+		FailedRequests:     failed,
+		mu:                 &sync.RWMutex{},
 	}
 	gh.Projects = gh.ListProjects()
-	gh.ListRepos()
 	return &gh
 }
 
-func initClient() *github.Client {
+func initClient(failed *FailedRequests) *github.Client {
 	ctx := context.Background()
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: viper.GetString("github_token")},
 	)
 	tc := oauth2.NewClient(ctx, ts)
-	return github.NewClient(tc)
+	tc.Transport = NewRetryTransport(&metricsTransport{next: tc.Transport}, failed)
+	c := github.NewClient(tc)
+	if apiURL := viper.GetString("github_api_url"); apiURL != "" {
+		// GitHub Enterprise serves the API under its own host
+		u, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/")
+		if err != nil {
+			logrus.WithError(err).WithField("url", apiURL).Fatal("Invalid GitHub API URL")
+		}
+		c.BaseURL = u
+	}
+	return c
 }
 
-// ListRepos shows all the repos in an org
-func (g *GH) ListRepos() {
+// ListRepos lists all the repos in an org
+func (g *GH) ListRepos() []*github.Repository {
 	ctx := context.Background()
-	repos, rsp, err := g.c.Repositories.ListByOrg(ctx, g.org, nil)
-	if err != nil {
-		log.Println("Unable to List Repos in Org...", rsp, err)
+	opts := &github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var repos []*github.Repository
+	for {
+		page, rsp, err := g.c.Repositories.ListByOrg(ctx, g.org, opts)
+		if err != nil {
+			g.log.WithError(err).WithField("org", g.org).Error("Unable to List Repos in Org")
+			return repos
+		}
+		repos = append(repos, page...)
+		if rsp.NextPage == 0 {
+			break
+		}
+		opts.Page = rsp.NextPage
 	}
-	log.Println("Listing Repos..")
-	g.repos = repos
+	g.log.WithField("repos", len(repos)).Debug("Listing Repos")
+	return repos
 }
 
 // ListProjects shows all the projects in an org
 func (g *GH) ListProjects() []*github.Project {
 	ctx := context.Background()
 	projectOptions := &github.ProjectListOptions{State: "open"}
-	projects, rsp, err := g.c.Organizations.ListProjects(ctx, g.org, projectOptions)
+	projects, _, err := g.c.Organizations.ListProjects(ctx, g.org, projectOptions)
 	if err != nil {
-		log.Println("Unable to List Projects in Org", rsp, g.org, err)
+		g.log.WithError(err).WithField("org", g.org).Error("Unable to List Projects in Org")
 	}
 	return projects
 }
 
+// OrgProjects returns the projects of the org listed last
+func (g *GH) OrgProjects() []*github.Project {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.Projects
+}
+
 // GetProjectID gets the id of project to be added on all PRs/Issues by default
-func (g *GH) GetProjectID(name string) *int64 {
+func (g *GH) GetProjectID(name string) (int64, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
 	for _, p := range g.Projects {
-		log.Println("Found Project ID:", *p.ID, "For Project:", *p.Name)
 		if *p.Name == name {
-			log.Println("Found Project ID:", *p.ID, "For Project:", *p.Name)
-			return p.ID
+			g.log.WithFields(logrus.Fields{"project": *p.Name, "project_id": *p.ID}).Debug("Found Project ID")
+			return *p.ID, nil
 		}
 	}
-	log.Println("Couldn't Find Project ID for:", name, g.Projects)
-	return nil
+	return 0, fmt.Errorf("project %q not found in org %s", name, g.org)
 }
 
-// GetDefaultProjectColumns sets data for default project columns
-func (g *GH) GetDefaultProjectColumns() {
-	g.defaultColumns = g.ListProjectColumns(g.DefaultProjectID)
+// ReloadProjects lists the projects of the org again, keeping the ones known
+// when they can't be listed
+func (g *GH) ReloadProjects() {
+	if projects := g.ListProjects(); projects != nil {
+		g.mu.Lock()
+		g.Projects = projects
+		g.mu.Unlock()
+	}
 }
 
-// GetDefaultColumnID returns the id of the default column for new PRs/Issues
-func (g *GH) GetDefaultColumnID() {
-	if v, ok := g.GetCardColumnIDByName(g.defaultColumns, g.defaultColumnName); ok {
-		//the value exists
-		g.defaultColumnID = v
+// LoadDefaultProject lists the projects of the org again and resolves the
+// default project and the column for new PRs/Issues. The project and column
+// are swapped in together once both are found.
+func (g *GH) LoadDefaultProject() error {
+	g.ReloadProjects()
+	projectID, err := g.GetProjectID(g.DefaultProjectName)
+	if err != nil {
+		return err
 	}
+	columns, err := g.ListProjectColumns(projectID)
+	if err != nil {
+		return err
+	}
+	columnID, ok := g.GetCardColumnIDByName(columns, g.defaultColumnName)
+	if !ok {
+		return fmt.Errorf("column %q not found in project %q", g.defaultColumnName, g.DefaultProjectName)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.DefaultProjectID, g.defaultColumns, g.defaultColumnID = projectID, columns, columnID
+	return nil
 }
 
 // ListHooks gets all of the hooks in an org
 func (g *GH) ListHooks() []*github.Hook {
 	ctx := context.Background()
-	hooks, rsp, err := g.c.Organizations.ListHooks(ctx, g.org, nil)
+	hooks, _, err := g.c.Organizations.ListHooks(ctx, g.org, nil)
 	if err != nil {
-		log.Println("Unable to List Hooks in Org", g.org, rsp, err)
+		g.log.WithError(err).WithField("org", g.org).Error("Unable to List Hooks in Org")
 		return nil
 	}
-	log.Println("Listing Hooks...", hooks)
+	g.log.WithField("hooks", len(hooks)).Debug("Listing Hooks")
 	return hooks
 }
 
-// HookExists checks if a hook with provided URL already exists
-func (g *GH) HookExists(hooks []*github.Hook) bool {
-	for _, h := range hooks {
-		if g.hookURL == h.Config["url"].(string) {
-			return true
-		}
-	}
-	return false
-}
-
-// CreateHook creates an organization hook to monitor Issues & PRs
-func (g *GH) CreateHook() *github.Hook {
-	ctx := context.Background()
-	hookEvents := []string{"pull_request", "issues"}
-	hookConfig := map[string]interface{}{
-		"url":          g.hookURL,
-		"content_type": "json",
-	}
-	hookOptions := &github.Hook{
-		Events: hookEvents,
-		Config: hookConfig,
-	}
-	hook, rsp, err := g.c.Organizations.CreateHook(ctx, g.org, hookOptions)
-	if rsp.StatusCode == 404 {
-		log.Println("Unauthorized to Create Hook in Org")
-	}
-	if err != nil {
-		log.Println("Unable to Create Hook in Org", rsp, err)
-	}
-	log.Println("Created Hook: ", hook)
-	return hook
-}
-
 // ListProjectColumns gets all the columns of a project
-func (g *GH) ListProjectColumns(prjID int64) []*github.ProjectColumn {
+func (g *GH) ListProjectColumns(prjID int64) ([]*github.ProjectColumn, error) {
 	ctx := context.Background()
 	columns, _, err := g.c.Projects.ListProjectColumns(ctx, prjID, &github.ListOptions{})
 	if err != nil {
-		log.Fatal("Unable to List columns in project ", err)
-		return nil
+		return nil, fmt.Errorf("listing columns of project %d: %w", prjID, err)
 	}
-	return columns
+	return columns, nil
 }
 
 // GetCardColumnIDByName returns the ID of a column given a name
 func (g *GH) GetCardColumnIDByName(columns []*github.ProjectColumn, columnName string) (int64, bool) {
 	for _, c := range columns {
-		log.Println("columns:", *c.Name)
 		if *c.Name == columnName {
-			log.Println("Column ID Found:", *c.ID)
+			g.log.WithFields(logrus.Fields{"column": columnName, "column_id": *c.ID}).Debug("Column ID Found")
 			return *c.ID, true
 		}
 	}
@@ -167,80 +199,203 @@ func (g *GH) GetCardColumnIDByName(columns []*github.ProjectColumn, columnName s
 }
 
 // ListProjectCards gets all the cards in a projects column
-func (g *GH) ListProjectCards(colID int64) []*github.ProjectCard {
+func (g *GH) ListProjectCards(colID int64) ([]*github.ProjectCard, error) {
+	return g.ListProjectCardsByState(colID, "")
+}
+
+// ListProjectCardsByState gets all the cards in a projects column filtered by
+// archived state ("all", "archived" or "not_archived", empty for the default)
+func (g *GH) ListProjectCardsByState(colID int64, archivedState string) ([]*github.ProjectCard, error) {
 	ctx := context.Background()
-	cards, _, err := g.c.Projects.ListProjectCards(ctx, colID, &github.ProjectCardListOptions{})
-	if err != nil {
-		log.Fatal("Unable to list cards in project ", err)
-		return nil
+	opts := &github.ProjectCardListOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	if archivedState != "" {
+		opts.ArchivedState = &archivedState
 	}
-	return cards
+	var cards []*github.ProjectCard
+	for {
+		page, rsp, err := g.c.Projects.ListProjectCards(ctx, colID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing cards of column %d: %w", colID, err)
+		}
+		cards = append(cards, page...)
+		if rsp.NextPage == 0 {
+			break
+		}
+		opts.Page = rsp.NextPage
+	}
+	return cards, nil
+}
+
+// ArchiveProjectCard archives a project card without deleting it
+func (g *GH) ArchiveProjectCard(cardID int64) error {
+	ctx := context.Background()
+	archived := true
+	_, _, err := g.c.Projects.UpdateProjectCard(ctx, cardID, &github.ProjectCardOptions{Archived: &archived})
+	return err
 }
 
-func (g *GH) GetProjectCardByIssue(issue github.Issue, repoName string, prjID int64) *github.ProjectCard {
-	columns := g.ListProjectColumns(prjID)
+// GetProjectCardByIssue finds the card of an Issue or PR in a project, nil
+// when it has none
+func (g *GH) GetProjectCardByIssue(issue github.Issue, repoName string, prjID int64) (*github.ProjectCard, error) {
+	columns, err := g.ListProjectColumns(prjID)
+	if err != nil {
+		return nil, err
+	}
 	for _, col := range columns {
-		cards := g.ListProjectCards(*col.ID)
+		cards, err := g.ListProjectCards(*col.ID)
+		if err != nil {
+			return nil, err
+		}
 		for _, card := range cards {
+			if card.ContentURL == nil {
+				continue
+			}
 			u := strings.Split(*card.ContentURL, "/")
-			if u[len(u) -1] == strconv.Itoa(*issue.Number) && u[len(u) -3] == repoName {
-				return card
+			if u[len(u)-1] == strconv.Itoa(*issue.Number) && u[len(u)-3] == repoName {
+				return card, nil
 			}
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 // CreateProjectCard adds the Project to an Issue or PR
-func (g *GH) CreateProjectCard(contentType string, id int64, columnID int64) {
-	log.Println("Creating project card...")
+func (g *GH) CreateProjectCard(contentType string, id int64, columnID int64) error {
 	ctx := context.Background()
 	projectCardOptions := &github.ProjectCardOptions{
 		ContentID:   id,
 		ContentType: contentType,
 	}
-	card, rsp, err := g.c.Projects.CreateProjectCard(ctx, columnID, projectCardOptions)
+	card, _, err := g.c.Projects.CreateProjectCard(ctx, columnID, projectCardOptions)
+	if alreadyExists(err) {
+		// a retry of a delivery whose card was created before it failed
+		g.log.WithFields(logrus.Fields{"content_type": contentType, "content_id": id, "column_id": columnID}).Info("Project Card Already Exists")
+		return nil
+	}
+	if err != nil {
+		g.log.WithError(err).WithFields(logrus.Fields{"content_type": contentType, "content_id": id, "column_id": columnID}).Error("Problem Creating Project Card")
+		return err
+	}
+	g.log.WithFields(logrus.Fields{"card": card.GetID(), "column_id": columnID}).Info("Created Project Card")
+	return nil
+}
+
+// alreadyExists checks if GitHub refused to create something because it
+// already exists
+func alreadyExists(err error) bool {
+	var e *github.ErrorResponse
+	return errors.As(err, &e) && e.Response != nil && e.Response.StatusCode == http.StatusUnprocessableEntity &&
+		strings.Contains(strings.ToLower(e.Error()), "already")
+}
+
+// MoveProjectCard moves a card to the top of another column in the same project
+func (g *GH) MoveProjectCard(cardID int64, columnID int64) error {
+	ctx := context.Background()
+	_, err := g.c.Projects.MoveProjectCard(ctx, cardID, &github.ProjectCardMoveOptions{Position: "top", ColumnID: columnID})
+	return err
+}
+
+// AddLabels adds labels to an Issue or PR
+func (g *GH) AddLabels(repo string, number int, labels ...string) error {
+	ctx := context.Background()
+	_, _, err := g.c.Issues.AddLabelsToIssue(ctx, g.org, repo, number, labels)
+	return err
+}
+
+// CreateComment comments on an Issue or PR
+func (g *GH) CreateComment(repo string, number int, body string) error {
+	ctx := context.Background()
+	_, _, err := g.c.Issues.CreateComment(ctx, g.org, repo, number, &github.IssueComment{Body: &body})
+	return err
+}
+
+// GetPermissionLevel gets a user's permission level on a repo
+func (g *GH) GetPermissionLevel(repo string, user string) (string, error) {
+	ctx := context.Background()
+	p, _, err := g.c.Repositories.GetPermissionLevel(ctx, g.org, repo, user)
+	if err != nil {
+		return "", err
+	}
+	return p.GetPermission(), nil
+}
+
+// CreateCommentReaction reacts to an Issue or PR comment
+func (g *GH) CreateCommentReaction(repo string, commentID int64, reaction string) error {
+	ctx := context.Background()
+	_, _, err := g.c.Reactions.CreateIssueCommentReaction(ctx, g.org, repo, commentID, reaction)
+	return err
+}
+
+// ParseContentURL returns the repo name and number of a card's Issue or PR
+func ParseContentURL(contentURL string) (string, int, bool) {
+	u := strings.Split(contentURL, "/")
+	if len(u) < 3 {
+		return "", 0, false
+	}
+	number, err := strconv.Atoi(u[len(u)-1])
 	if err != nil {
-		log.Println("projectCardOptions:", projectCardOptions)
-		log.Println("Problem Creating Project Card", rsp, err)
-	} else {
-		log.Println("Created Project Card", card)
+		return "", 0, false
 	}
+	return u[len(u)-3], number, true
+}
+
+// DeleteProjectCard deletes a Project Card
+func (g *GH) DeleteProjectCard(cardID int64) error {
+	ctx := context.Background()
+	_, err := g.c.Projects.DeleteProjectCard(ctx, cardID)
+	return err
 }
 
 // DeleteProjectCard deletes a Project Card given the issue id and label
-func (g *GH) DeleteProjectIssueCard(contentType string, issue github.Issue, repoName string, projectName string) {
-	log.Println("Deleting Project Card")
+func (g *GH) DeleteProjectIssueCard(contentType string, issue github.Issue, repoName string, projectName string) error {
 	ctx := context.Background()
-	projectID := g.GetProjectID(projectName)
-	card := g.GetProjectCardByIssue(issue, repoName, *projectID)
+	projectID, err := g.GetProjectID(projectName)
+	if err != nil {
+		return err
+	}
+	card, err := g.GetProjectCardByIssue(issue, repoName, projectID)
+	if err != nil {
+		return err
+	}
 	if card == nil {
-		log.Print("There is no card to delete for issue #", issue.ID)
-		return
+		g.log.WithField("issue", issue.GetNumber()).Debug("There is no card to delete")
+		return nil
 	}
-	_, err := g.c.Projects.DeleteProjectCard(ctx, *card.ID)
+	_, err = g.c.Projects.DeleteProjectCard(ctx, *card.ID)
 	if err != nil {
- 		log.Print("Error Deleting Card",*card.ID)
+		return fmt.Errorf("deleting card %d: %w", *card.ID, err)
 	}
+	g.log.WithField("card", *card.ID).Info("Deleted Project Card")
+	return nil
 }
 
 // ProccessPullRequestEvent takes a PR event and performs actions on it
-func (g *GH) ProccessPullRequestEvent(e *github.PullRequestEvent) {
-	log.Println("Received PR Event! Action: ", *e.Action)
+func (g *GH) ProccessPullRequestEvent(e *github.PullRequestEvent) error {
+	g.log.WithField("action", *e.Action).Debug("Received PR Event")
 	if *e.Action == "opened" && *e.PullRequest.State == "open" {
-		log.Println("Processing Opened PR Event...")
-		log.Println("PR ID:", *e.PullRequest.ID)
-		log.Println("Project Column Name:", g.defaultColumnName, "Column ID: ", g.defaultColumnID, "Proj ID:", g.DefaultProjectID)
-		g.CreateProjectCard("PullRequest", *e.PullRequest.ID, g.defaultColumnID)
+		return g.createDefaultCard("PullRequest", *e.PullRequest.ID)
 	}
+	return nil
 }
 
 // ProccessIssuesEvent takes an Issue event and performs actions on it
-func (g *GH) ProccessIssuesEvent(e *github.IssuesEvent) {
-	log.Print("Received Issues Event! ")
+func (g *GH) ProccessIssuesEvent(e *github.IssuesEvent) error {
+	g.log.WithField("action", *e.Action).Debug("Received Issues Event")
 	if *e.Action == "opened" {
-		g.CreateProjectCard("Issue", *e.Issue.ID, g.defaultColumnID)
+		return g.createDefaultCard("Issue", *e.Issue.ID)
+	}
+	return nil
+}
+
+// createDefaultCard adds an Issue or PR to the default column
+func (g *GH) createDefaultCard(contentType string, id int64) error {
+	g.mu.RLock()
+	columnID := g.defaultColumnID
+	g.mu.RUnlock()
+	if columnID == 0 {
+		return fmt.Errorf("default column %q is not loaded", g.defaultColumnName)
 	}
+	return g.CreateProjectCard(contentType, id, columnID)
 }
 
 // GetPR gets PR data
@@ -248,18 +403,103 @@ func (g *GH) GetPR(repo string, number int) (*github.PullRequest, *github.Respon
 	ctx := context.Background()
 	pr, rsp, err := g.c.PullRequests.Get(ctx, g.org, repo, number)
 	if err != nil {
-		log.Println("Error Retrieving PR")
+		g.log.WithError(err).WithFields(logrus.Fields{"repo": repo, "number": number}).Error("Error Retrieving PR")
 		return nil, rsp
 	}
 	return pr, rsp
 }
 
+// ListIssueTimeline gets all the timeline events of an Issue or PR
+func (g *GH) ListIssueTimeline(repo string, number int) []*github.Timeline {
+	ctx := context.Background()
+	opts := &github.ListOptions{PerPage: 100}
+	var timeline []*github.Timeline
+	for {
+		page, rsp, err := g.c.Issues.ListIssueTimeline(ctx, g.org, repo, number, opts)
+		if err != nil {
+			g.log.WithError(err).WithFields(logrus.Fields{"repo": repo, "number": number}).Error("Error Retrieving Timeline")
+			return timeline
+		}
+		timeline = append(timeline, page...)
+		if rsp.NextPage == 0 {
+			return timeline
+		}
+		opts.Page = rsp.NextPage
+	}
+}
+
+// ListRepoIssues gets all the Issues and PRs of a repo in a state updated
+// after since, a zero since lists them all
+func (g *GH) ListRepoIssues(repo string, state string, since time.Time) []*github.Issue {
+	ctx := context.Background()
+	opts := &github.IssueListByRepoOptions{State: state, Since: since, ListOptions: github.ListOptions{PerPage: 100}}
+	var issues []*github.Issue
+	for {
+		page, rsp, err := g.c.Issues.ListByRepo(ctx, g.org, repo, opts)
+		if err != nil {
+			g.log.WithError(err).WithField("repo", repo).Error("Error Listing Issues")
+			return issues
+		}
+		issues = append(issues, page...)
+		if rsp.NextPage == 0 {
+			return issues
+		}
+		opts.Page = rsp.NextPage
+	}
+}
+
+// ListMilestoneIssues gets all the Issues and PRs of the milestone with a
+// title in a repo
+func (g *GH) ListMilestoneIssues(repo string, title string) []*github.Issue {
+	ctx := context.Background()
+	m := g.findMilestone(ctx, repo, title)
+	if m == nil {
+		return nil
+	}
+	opts := &github.IssueListByRepoOptions{Milestone: strconv.Itoa(m.GetNumber()), State: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	var issues []*github.Issue
+	for {
+		page, rsp, err := g.c.Issues.ListByRepo(ctx, g.org, repo, opts)
+		if err != nil {
+			g.log.WithError(err).WithFields(logrus.Fields{"repo": repo, "milestone": title}).Error("Error Listing Milestone Issues")
+			return issues
+		}
+		issues = append(issues, page...)
+		if rsp.NextPage == 0 {
+			return issues
+		}
+		opts.Page = rsp.NextPage
+	}
+}
+
+// findMilestone pages through the milestones of a repo for the one with a
+// title
+func (g *GH) findMilestone(ctx context.Context, repo string, title string) *github.Milestone {
+	opts := &github.MilestoneListOptions{State: "all", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		milestones, rsp, err := g.c.Issues.ListMilestones(ctx, g.org, repo, opts)
+		if err != nil {
+			g.log.WithError(err).WithField("repo", repo).Error("Error Listing Milestones")
+			return nil
+		}
+		for _, m := range milestones {
+			if m.GetTitle() == title {
+				return m
+			}
+		}
+		if rsp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = rsp.NextPage
+	}
+}
+
 // GetIssue gets issue data
 func (g *GH) GetIssue(repo string, number int) (*github.Issue, *github.Response) {
 	ctx := context.Background()
 	i, rsp, err := g.c.Issues.Get(ctx, g.org, repo, number)
 	if err != nil {
-		log.Println("Error Retrieving Issue")
+		g.log.WithError(err).WithFields(logrus.Fields{"repo": repo, "number": number}).Error("Error Retrieving Issue")
 		return nil, rsp
 	}
 	return i, rsp
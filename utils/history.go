@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
+)
+
+// CardEvent records a card being created in, moved to or removed from a column
+type CardEvent struct {
+	CardID   int64     `json:"CardID"`
+	Action   string    `json:"Action"`
+	ColumnID int64     `json:"ColumnID,omitempty"`
+	Column   string    `json:"Column,omitempty"`
+	At       time.Time `json:"At"`
+}
+
+// CardHistory keeps the movements of cards received from project_card events,
+// in memory or in a Store when one is set
+type CardHistory struct {
+	mu     sync.Mutex
+	events map[int64][]CardEvent
+	store  *Store
+}
+
+// NewCardHistory creates a new instance of CardHistory, store may be nil
+func NewCardHistory(store *Store) *CardHistory {
+	return &CardHistory{events: map[int64][]CardEvent{}, store: store}
+}
+
+// Record stores a project_card event
+func (h *CardHistory) Record(e *github.ProjectCardEvent) {
+	card := e.GetProjectCard()
+	at := time.Now()
+	if card.UpdatedAt != nil {
+		at = card.UpdatedAt.Time
+	}
+	h.Add(CardEvent{
+		CardID:   card.GetID(),
+		Action:   e.GetAction(),
+		ColumnID: card.GetColumnID(),
+		At:       at,
+	})
+}
+
+// Add stores card events keeping them sorted by time
+func (h *CardHistory) Add(events ...CardEvent) {
+	if h.store != nil {
+		for _, e := range events {
+			if err := h.store.RecordCardEvent(e); err != nil {
+				logrus.WithError(err).WithField("card", e.CardID).Error("Error Storing Card Event")
+			}
+		}
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range events {
+		h.events[e.CardID] = append(h.events[e.CardID], e)
+		sort.SliceStable(h.events[e.CardID], func(i, j int) bool {
+			return h.events[e.CardID][i].At.Before(h.events[e.CardID][j].At)
+		})
+	}
+}
+
+// Events returns the recorded events of a card, oldest first
+func (h *CardHistory) Events(cardID int64) []CardEvent {
+	if h.store != nil {
+		events, err := h.store.CardEvents(cardID)
+		if err != nil {
+			logrus.WithError(err).WithField("card", cardID).Error("Error Reading Card Events")
+		}
+		return events
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]CardEvent(nil), h.events[cardID]...)
+}
+
+// TimelineCardEvents converts the project events of an Issue timeline into
+// card events for a card
+func TimelineCardEvents(timeline []*github.Timeline, cardID int64) []CardEvent {
+	actions := map[string]string{
+		"added_to_project":         "created",
+		"moved_columns_in_project": "moved",
+		"removed_from_project":     "deleted",
+	}
+	var events []CardEvent
+	for _, t := range timeline {
+		action, ok := actions[t.GetEvent()]
+		if !ok || t.ProjectCard == nil || t.ProjectCard.GetID() != cardID || t.CreatedAt == nil {
+			continue
+		}
+		events = append(events, CardEvent{
+			CardID: cardID,
+			Action: action,
+			Column: t.ProjectCard.GetColumnName(),
+			At:     *t.CreatedAt,
+		})
+	}
+	return events
+}
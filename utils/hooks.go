@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/spf13/viper"
+)
+
+// HookEvents are the events the hooks send to projector
+var HookEvents = []string{"pull_request", "issues", "project_card", "issue_comment"}
+
+// HookOptions tunes a hook reconciliation
+type HookOptions struct {
+	// RotateSecret pushes the configured secret even to a hook that has one
+	RotateSecret bool
+	// StaleURLs are old projector URLs whose hooks are removed
+	StaleURLs []string
+	// DryRun reports the changes without making them
+	DryRun bool
+}
+
+// HookChange is a change made to a hook while reconciling
+type HookChange struct {
+	Repo    string   `json:"Repo,omitempty"`
+	HookID  int64    `json:"HookID,omitempty"`
+	URL     string   `json:"URL"`
+	Action  string   `json:"Action"`
+	Changes []string `json:"Changes,omitempty"`
+}
+
+// desiredHook is the hook projector needs
+func (g *GH) desiredHook() *github.Hook {
+	config := map[string]interface{}{
+		"url":          g.hookURL,
+		"content_type": "json",
+	}
+	if len(g.Secret) > 0 {
+		config["secret"] = string(g.Secret)
+	}
+	return &github.Hook{Events: HookEvents, Config: config, Active: github.Bool(true)}
+}
+
+// HookDrift lists the settings of a hook that differ from the desired ones.
+// GitHub doesn't return secrets, so only whether one is set is compared.
+func (g *GH) HookDrift(h *github.Hook) []string {
+	drift := []string{}
+	events := append([]string{}, h.Events...)
+	sort.Strings(events)
+	desired := append([]string{}, HookEvents...)
+	sort.Strings(desired)
+	if strings.Join(events, ",") != strings.Join(desired, ",") {
+		drift = append(drift, "events")
+	}
+	if h.Config["content_type"] != "json" {
+		drift = append(drift, "content_type")
+	}
+	if _, ok := h.Config["secret"]; ok != (len(g.Secret) > 0) {
+		drift = append(drift, "secret")
+	}
+	if !h.GetActive() {
+		drift = append(drift, "active")
+	}
+	return drift
+}
+
+// CheckHookMode verifies hook_mode is org or repo, with repos to install
+// hooks on in repo mode
+func CheckHookMode() error {
+	switch mode := viper.GetString("hook_mode"); {
+	case mode == "" || mode == "org":
+		return nil
+	case mode != "repo":
+		return fmt.Errorf("hook mode must be org or repo, not %q", mode)
+	case len(hookRepos()) == 0:
+		return errors.New("repo hook mode needs hook repos")
+	}
+	return nil
+}
+
+// hookRepos returns the repos of hook_repos when hook_mode is repo, and nil
+// for the org hook
+func hookRepos() []string {
+	if viper.GetString("hook_mode") != "repo" {
+		return nil
+	}
+	repos := []string{}
+	for _, repo := range strings.Split(viper.GetString("hook_repos"), ",") {
+		if repo = strings.TrimSpace(repo); repo != "" {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+// hookTargets returns where hooks are installed, the org as "" or each repo
+func (g *GH) hookTargets() []string {
+	if g.hookRepos == nil {
+		return []string{""}
+	}
+	return g.hookRepos
+}
+
+// hookOwner describes where a hook is installed for messages
+func (g *GH) hookOwner(repo string) string {
+	if repo == "" {
+		return "org " + g.org
+	}
+	return "repo " + g.org + "/" + repo
+}
+
+func (g *GH) listHooks(ctx context.Context, repo string) ([]*github.Hook, error) {
+	var hooks []*github.Hook
+	var err error
+	if repo == "" {
+		hooks, _, err = g.c.Organizations.ListHooks(ctx, g.org, nil)
+	} else {
+		hooks, _, err = g.c.Repositories.ListHooks(ctx, g.org, repo, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing hooks of %s: %w", g.hookOwner(repo), err)
+	}
+	return hooks, nil
+}
+
+func (g *GH) createHook(ctx context.Context, repo string) (*github.Hook, error) {
+	var hook *github.Hook
+	var err error
+	if repo == "" {
+		hook, _, err = g.c.Organizations.CreateHook(ctx, g.org, g.desiredHook())
+	} else {
+		hook, _, err = g.c.Repositories.CreateHook(ctx, g.org, repo, g.desiredHook())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating hook in %s: %w", g.hookOwner(repo), err)
+	}
+	return hook, nil
+}
+
+// editHook updates a hook, always with the configured secret as GitHub drops
+// the secret of a hook edited without one
+func (g *GH) editHook(ctx context.Context, repo string, id int64) error {
+	var err error
+	if repo == "" {
+		_, _, err = g.c.Organizations.EditHook(ctx, g.org, id, g.desiredHook())
+	} else {
+		_, _, err = g.c.Repositories.EditHook(ctx, g.org, repo, id, g.desiredHook())
+	}
+	if err != nil {
+		return fmt.Errorf("updating hook %d of %s: %w", id, g.hookOwner(repo), err)
+	}
+	return nil
+}
+
+func (g *GH) deleteHook(ctx context.Context, repo string, id int64) error {
+	var err error
+	if repo == "" {
+		_, err = g.c.Organizations.DeleteHook(ctx, g.org, id)
+	} else {
+		_, err = g.c.Repositories.DeleteHook(ctx, g.org, repo, id)
+	}
+	if err != nil {
+		return fmt.Errorf("deleting hook %d of %s: %w", id, g.hookOwner(repo), err)
+	}
+	return nil
+}
+
+// ReconcileHooks brings the org hook, or the hook of each repo in repo hook
+// mode, to the desired events, content type, secret and active flag, creating
+// it when missing. Duplicates of it and hooks pointing at stale URLs are
+// removed.
+func (g *GH) ReconcileHooks(opts HookOptions) ([]HookChange, error) {
+	ctx := context.Background()
+	changes := []HookChange{}
+	var errs []string
+	if err := g.checkOrgHook(ctx); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, repo := range g.hookTargets() {
+		c, err := g.reconcileHook(ctx, repo, opts)
+		changes = append(changes, c...)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return changes, errors.New(strings.Join(errs, "; "))
+	}
+	return changes, nil
+}
+
+// checkOrgHook makes sure no org hook sends events to projector in repo hook
+// mode, as each event would arrive twice. Tokens without org hook rights
+// can't have created one, so failing to list them is ignored.
+func (g *GH) checkOrgHook(ctx context.Context) error {
+	if g.hookRepos == nil {
+		return nil
+	}
+	hooks, err := g.listHooks(ctx, "")
+	if err != nil {
+		g.log.WithError(err).Debug("Unable to check for an org hook")
+		return nil
+	}
+	for _, h := range hooks {
+		if url, _ := h.Config["url"].(string); url == g.hookURL {
+			return fmt.Errorf("org hook %d also sends events to %s, remove it in repo hook mode", h.GetID(), g.hookURL)
+		}
+	}
+	return nil
+}
+
+// reconcileHook reconciles the hook of the org, or of a repo
+func (g *GH) reconcileHook(ctx context.Context, repo string, opts HookOptions) ([]HookChange, error) {
+	hooks, err := g.listHooks(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	stale := map[string]bool{}
+	for _, url := range opts.StaleURLs {
+		stale[url] = url != g.hookURL
+	}
+	changes := []HookChange{}
+	var ours *github.Hook
+	for _, h := range hooks {
+		url, _ := h.Config["url"].(string)
+		if url == g.hookURL && ours == nil {
+			ours = h
+			continue
+		}
+		if url != g.hookURL && !stale[url] {
+			continue
+		}
+		changes = append(changes, HookChange{Repo: repo, HookID: h.GetID(), URL: url, Action: "deleted"})
+		if opts.DryRun {
+			continue
+		}
+		if err := g.deleteHook(ctx, repo, h.GetID()); err != nil {
+			return changes, err
+		}
+	}
+	if ours == nil {
+		change := HookChange{Repo: repo, URL: g.hookURL, Action: "created"}
+		if !opts.DryRun {
+			hook, err := g.createHook(ctx, repo)
+			if err != nil {
+				return changes, err
+			}
+			change.HookID = hook.GetID()
+		}
+		return append(changes, change), nil
+	}
+	drift := g.HookDrift(ours)
+	if opts.RotateSecret && len(g.Secret) > 0 && !contains(drift, "secret") {
+		drift = append(drift, "secret")
+	}
+	if len(drift) == 0 {
+		return changes, nil
+	}
+	changes = append(changes, HookChange{Repo: repo, HookID: ours.GetID(), URL: g.hookURL, Action: "updated", Changes: drift})
+	if opts.DryRun {
+		return changes, nil
+	}
+	return changes, g.editHook(ctx, repo, ours.GetID())
+}
+
+// ValidatePayload checks the signature of a webhook delivery against the hook
+// secret, or the previous one while a rotation is under way, and returns its
+// payload
+func (g *GH) ValidatePayload(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := validatePayload(r, body, g.Secret)
+	if err != nil && len(g.PreviousSecret) > 0 {
+		payload, err = validatePayload(r, body, g.PreviousSecret)
+	}
+	return payload, err
+}
+
+func validatePayload(r *http.Request, body []byte, secret []byte) ([]byte, error) {
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return github.ValidatePayload(r, secret)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
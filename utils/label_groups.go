@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LabelGroup buckets labels sharing a prefix such as "type:" or "priority:".
+// With Sum the numeric values of the labels are summed as story points.
+type LabelGroup struct {
+	Name   string
+	Prefix string
+	Sum    bool
+}
+
+// GroupCount shows the items per value of a label group
+type GroupCount struct {
+	Name    string         `json:"Name"`
+	Buckets map[string]int `json:"Buckets"`
+	Points  float64        `json:"Points,omitempty"`
+}
+
+// PeriodVelocity shows the items and story points closed in a period
+type PeriodVelocity struct {
+	Start       time.Time `json:"Start"`
+	End         time.Time `json:"End"`
+	ItemsClosed int       `json:"ItemsClosed"`
+	StoryPoints float64   `json:"StoryPoints"`
+}
+
+// LabelGroups returns the configured label groups
+func (r *RulesProcessor) LabelGroups() []LabelGroup {
+	var groups []LabelGroup
+	if err := r.decodeRules("LabelGroups", &groups); err != nil {
+		r.log.WithError(err).Error("Error Decoding Label Groups")
+	}
+	return groups
+}
+
+// labelValue returns the value of a label in a group, "effort: 3" is "3"
+func (g LabelGroup) labelValue(label string) (string, bool) {
+	if !strings.HasPrefix(label, g.Prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(label, g.Prefix)), true
+}
+
+// GroupLabels counts the cards per value of each group and sums story points
+func GroupLabels(groups []LabelGroup, cards []*Card) []*GroupCount {
+	var counts []*GroupCount
+	for _, g := range groups {
+		gc := &GroupCount{Name: g.Name, Buckets: map[string]int{}}
+		for _, c := range cards {
+			for _, l := range c.Labels {
+				v, ok := g.labelValue(l.GetName())
+				if !ok {
+					continue
+				}
+				gc.Buckets[v]++
+				if g.Sum {
+					gc.Points += parsePoints(v)
+				}
+			}
+		}
+		counts = append(counts, gc)
+	}
+	return counts
+}
+
+// StoryPoints sums the story points of a card over the summed groups
+func StoryPoints(groups []LabelGroup, c *Card) float64 {
+	points := 0.0
+	for _, g := range groups {
+		if !g.Sum {
+			continue
+		}
+		for _, l := range c.Labels {
+			if v, ok := g.labelValue(l.GetName()); ok {
+				points += parsePoints(v)
+			}
+		}
+	}
+	return points
+}
+
+// maxVelocityPeriods caps the periods of a report
+const maxVelocityPeriods = 104
+
+// Velocity buckets closed cards into periods starting at since
+func Velocity(groups []LabelGroup, cards []*Card, since time.Time, until time.Time, period time.Duration) []*PeriodVelocity {
+	if period <= 0 || !until.After(since) {
+		return nil
+	}
+	var periods []*PeriodVelocity
+	for start := since; start.Before(until); start = start.Add(period) {
+		end := start.Add(period)
+		if end.After(until) {
+			end = until
+		}
+		periods = append(periods, &PeriodVelocity{Start: start, End: end})
+	}
+	for _, c := range cards {
+		closed, ok := cardClosedAt(c)
+		if !ok || closed.Before(since) || !closed.Before(until) {
+			continue
+		}
+		i := sort.Search(len(periods), func(i int) bool { return periods[i].End.After(closed) })
+		if i == len(periods) {
+			continue
+		}
+		periods[i].ItemsClosed++
+		periods[i].StoryPoints += StoryPoints(groups, c)
+	}
+	return periods
+}
+
+func parsePoints(v string) float64 {
+	points, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0
+	}
+	return points
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+var (
+	// ErrRuleExists rejects a label rule whose name is taken
+	ErrRuleExists = errors.New("label rule already exists")
+	// ErrRuleNotFound is returned for label rules that are not configured
+	ErrRuleNotFound = errors.New("label rule not found")
+)
+
+// Validate checks a label rule has everything it needs to match events and
+// place cards
+func (l LabelRule) Validate() error {
+	switch {
+	case l.Name == "":
+		return errors.New("name is required")
+	case l.Label == "":
+		return errors.New("label is required")
+	case l.Project == "":
+		return errors.New("project is required")
+	case l.Column == "":
+		return errors.New("column is required")
+	case l.Content != "Issue" && l.Content != "PullRequest":
+		return fmt.Errorf("content must be Issue or PullRequest, not %q", l.Content)
+	case l.State != "open" && l.State != "closed":
+		return fmt.Errorf("state must be open or closed, not %q", l.State)
+	}
+	return nil
+}
+
+// RulesConfig returns the rules config in use with credentials and sink URLs
+// redacted
+func (r *RulesProcessor) RulesConfig() map[string]interface{} {
+	r.rcMu.RLock()
+	defer r.rcMu.RUnlock()
+	return Redact(r.rc.AllSettings())
+}
+
+// ListLabelRules returns the configured label rules
+func (r *RulesProcessor) ListLabelRules() ([]LabelRule, error) {
+	rules := []LabelRule{}
+	err := r.decodeRules("LabelRules", &rules)
+	return rules, err
+}
+
+// AddLabelRule adds a label rule to the rules config, reporting whether the
+// change was written to the rules config file
+func (r *RulesProcessor) AddLabelRule(rule LabelRule) (bool, error) {
+	if err := rule.Validate(); err != nil {
+		return false, err
+	}
+	return r.updateLabelRules(func(rules []LabelRule) ([]LabelRule, error) {
+		if labelRuleIndex(rules, rule.Name) >= 0 {
+			return nil, fmt.Errorf("%q: %w", rule.Name, ErrRuleExists)
+		}
+		return append(rules, rule), nil
+	})
+}
+
+// UpdateLabelRule replaces the label rule called name, reporting whether the
+// change was written to the rules config file
+func (r *RulesProcessor) UpdateLabelRule(name string, rule LabelRule) (bool, error) {
+	if err := rule.Validate(); err != nil {
+		return false, err
+	}
+	return r.updateLabelRules(func(rules []LabelRule) ([]LabelRule, error) {
+		i := labelRuleIndex(rules, name)
+		if i < 0 {
+			return nil, fmt.Errorf("%q: %w", name, ErrRuleNotFound)
+		}
+		if rule.Name != name && labelRuleIndex(rules, rule.Name) >= 0 {
+			return nil, fmt.Errorf("%q: %w", rule.Name, ErrRuleExists)
+		}
+		rules[i] = rule
+		return rules, nil
+	})
+}
+
+// DeleteLabelRule removes the label rule called name, reporting whether the
+// change was written to the rules config file
+func (r *RulesProcessor) DeleteLabelRule(name string) (bool, error) {
+	return r.updateLabelRules(func(rules []LabelRule) ([]LabelRule, error) {
+		i := labelRuleIndex(rules, name)
+		if i < 0 {
+			return nil, fmt.Errorf("%q: %w", name, ErrRuleNotFound)
+		}
+		return append(rules[:i], rules[i+1:]...), nil
+	})
+}
+
+// updateLabelRules applies change to the label rules. The change takes effect
+// right away and is written back to the rules config file when there is one,
+// it is only persisted when that succeeds.
+func (r *RulesProcessor) updateLabelRules(change func([]LabelRule) ([]LabelRule, error)) (bool, error) {
+	r.rcMu.Lock()
+	defer r.rcMu.Unlock()
+	rules := []LabelRule{}
+	if raw := r.rc.Get("LabelRules"); raw != nil {
+		if err := mapstructure.Decode(raw, &rules); err != nil {
+			return false, fmt.Errorf("decoding label rules: %w", err)
+		}
+	}
+	rules, err := change(rules)
+	if err != nil {
+		return false, err
+	}
+	config := make([]map[string]interface{}, len(rules))
+	for i, rule := range rules {
+		config[i] = map[string]interface{}{
+			"name":        rule.Name,
+			"description": rule.Description,
+			"column":      rule.Column,
+			"label":       rule.Label,
+			"project":     rule.Project,
+			"state":       rule.State,
+			"content":     rule.Content,
+		}
+	}
+	r.rc.Set("LabelRules", config)
+	if r.rc.ConfigFileUsed() == "" {
+		r.log.Warn("No rules config file, label rule changes last until restart")
+		return false, nil
+	}
+	if err := r.rc.WriteConfig(); err != nil {
+		r.log.WithError(err).WithField("file", r.rc.ConfigFileUsed()).Warn("Unable to write rules config, label rule changes last until restart")
+		return false, nil
+	}
+	return true, nil
+}
+
+func labelRuleIndex(rules []LabelRule, name string) int {
+	for i, rule := range rules {
+		if rule.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	github "github.com/google/go-github/v32/github"
+)
+
+// LifecycleRule defines what happens to cards when an Issue or PR is closed,
+// reopened, transferred, deleted or locked. Action "merged" only matches
+// closed PRs that were merged.
+type LifecycleRule struct {
+	Name    string
+	Action  string
+	Content string
+	Project string
+	// Column the card is moved to, or added to when missing
+	Column string
+	// Remove deletes the card instead
+	Remove bool
+}
+
+// issueTransfer holds the parts of a transferred event go-github doesn't parse
+type issueTransfer struct {
+	Changes struct {
+		NewIssue *github.Issue `json:"new_issue"`
+	} `json:"changes"`
+}
+
+// lifecycleEvent is the common subset of Issue and PR events
+type lifecycleEvent struct {
+	action    string
+	merged    bool
+	content   string
+	contentID int64
+	issue     github.Issue
+	repo      string
+}
+
+// Matches checks if a rule applies to an action on a content type
+func (rule LifecycleRule) Matches(action string, content string, merged bool) bool {
+	if rule.Content != "" && rule.Content != content {
+		return false
+	}
+	return rule.Action == action || (rule.Action == "merged" && action == "closed" && merged)
+}
+
+// ProcessLifecycleRules moves, removes or re-points cards of Issues and PRs
+// that changed state
+func (r *RulesProcessor) ProcessLifecycleRules(e interface{}, payload []byte) error {
+	var le lifecycleEvent
+	switch e := e.(type) {
+	case *github.PullRequestEvent:
+		pr := e.GetPullRequest()
+		le = lifecycleEvent{
+			action:    e.GetAction(),
+			merged:    pr.GetMerged(),
+			content:   "PullRequest",
+			contentID: pr.GetID(),
+			issue:     github.Issue{ID: pr.ID, Number: pr.Number},
+			repo:      e.GetRepo().GetName(),
+		}
+	case *github.IssuesEvent:
+		le = lifecycleEvent{
+			action:    e.GetAction(),
+			content:   "Issue",
+			contentID: e.GetIssue().GetID(),
+			issue:     *e.GetIssue(),
+			repo:      e.GetRepo().GetName(),
+		}
+	default:
+		return nil
+	}
+	var rules []LifecycleRule
+	if err := r.decodeRules("LifecycleRules", &rules); err != nil {
+		return fmt.Errorf("decoding lifecycle rules: %w", err)
+	}
+	var errs ruleErrors
+	for _, rule := range rules {
+		if !rule.Matches(le.action, le.content, le.merged) {
+			continue
+		}
+		r.log.WithField("rule", rule.Name).Info("Lifecycle rule matched")
+		matchedRule("lifecycle", rule.Name)
+		projID, err := r.gh.GetProjectID(rule.Project)
+		if err != nil {
+			errs = errs.add(rule.Name, err)
+			continue
+		}
+		card, err := r.gh.GetProjectCardByIssue(le.issue, le.repo, projID)
+		if err != nil {
+			errs = errs.add(rule.Name, err)
+			continue
+		}
+		switch {
+		case rule.Remove:
+			if card == nil {
+				continue
+			}
+			err = r.gh.DeleteProjectCard(*card.ID)
+		case le.action == "transferred":
+			err = r.repointCard(rule, card, payload)
+		case rule.Column != "":
+			err = r.placeCard(LabelRule{Name: rule.Name, Project: rule.Project, Column: rule.Column, Content: le.content}, le.contentID, card)
+		}
+		errs = errs.add(rule.Name, err)
+	}
+	return errs.err()
+}
+
+// repointCard replaces the card of a transferred Issue with one for the new
+// Issue, keeping its column unless the rule sets one
+func (r *RulesProcessor) repointCard(rule LifecycleRule, card *github.ProjectCard, payload []byte) error {
+	var t issueTransfer
+	if err := json.Unmarshal(payload, &t); err != nil || t.Changes.NewIssue == nil {
+		r.log.WithError(err).Warn("Unable to find the transferred Issue")
+		return nil
+	}
+	if rule.Column != "" {
+		rule := LabelRule{Name: rule.Name, Project: rule.Project, Column: rule.Column, Content: "Issue"}
+		if err := r.placeCard(rule, t.Changes.NewIssue.GetID(), nil); err != nil {
+			return err
+		}
+	} else if card != nil {
+		colID, ok := columnIDFromURL(card.GetColumnURL())
+		if !ok {
+			return fmt.Errorf("unable to get column ID from %s", card.GetColumnURL())
+		}
+		if err := r.gh.CreateProjectCard("Issue", t.Changes.NewIssue.GetID(), colID); err != nil {
+			return err
+		}
+	}
+	if card == nil {
+		return nil
+	}
+	return r.gh.DeleteProjectCard(*card.ID)
+}
+
+func columnIDFromURL(columnURL string) (int64, bool) {
+	u := strings.Split(columnURL, "/")
+	id, err := strconv.ParseInt(u[len(u)-1], 10, 64)
+	return id, err == nil
+}
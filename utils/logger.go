@@ -0,0 +1,64 @@
+package utils
+
+import (
+	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// ConfigureLogging sets the level and format of the logger from the log_level
+// and log_format (json or logfmt) settings
+func ConfigureLogging() {
+	level, err := logrus.ParseLevel(viper.GetString("log_level"))
+	if err != nil {
+		logrus.WithError(err).Warn("Invalid log level, using info")
+		level = logrus.InfoLevel
+	}
+	logrus.SetLevel(level)
+	if viper.GetString("log_format") == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true, FullTimestamp: true})
+	}
+}
+
+// newLog returns the default logger of GH, RulesProcessor and Reporter
+func newLog() *logrus.Entry {
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// WithLogger returns a copy of GH logging to l
+func (g *GH) WithLogger(l *logrus.Entry) *GH {
+	g.mu.RLock()
+	gh := *g
+	g.mu.RUnlock()
+	gh.log = l
+	return &gh
+}
+
+// WithLogger returns a RulesProcessor sharing the rules config and logging to l
+func (r *RulesProcessor) WithLogger(l *logrus.Entry) *RulesProcessor {
+	return &RulesProcessor{gh: r.gh.WithLogger(l), rc: r.rc, rcMu: r.rcMu, log: l, store: r.store}
+}
+
+// EventFields returns the repo and Issue or PR number of a webhook event
+func EventFields(event interface{}) logrus.Fields {
+	fields := logrus.Fields{}
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok && e.GetRepo() != nil {
+		fields["repo"] = e.GetRepo().GetName()
+	}
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		fields["number"] = e.GetPullRequest().GetNumber()
+	case *github.IssuesEvent:
+		fields["number"] = e.GetIssue().GetNumber()
+	case *github.IssueCommentEvent:
+		fields["number"] = e.GetIssue().GetNumber()
+	case *github.ProjectCardEvent:
+		fields["card"] = e.GetProjectCard().GetID()
+		if repo, number, ok := ParseContentURL(e.GetProjectCard().GetContentURL()); ok {
+			fields["repo"], fields["number"] = repo, number
+		}
+	}
+	return fields
+}
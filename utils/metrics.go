@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// WebhookDeliveries counts webhook deliveries by event, action and outcome
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "projector_webhook_deliveries_total",
+		Help: "Webhook deliveries by event, action and outcome.",
+	}, []string{"event", "action", "outcome"})
+	// WebhookDuration observes how long webhooks take to process
+	WebhookDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "projector_webhook_processing_seconds",
+		Help:    "Time spent processing a webhook delivery.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"event"})
+	// QueueDepth is the number of webhook deliveries being processed
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "projector_queue_depth",
+		Help: "Webhook deliveries waiting for or in processing.",
+	})
+	ruleMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "projector_rule_matches_total",
+		Help: "Rule matches by kind and rule name.",
+	}, []string{"kind", "rule"})
+	apiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "projector_github_api_calls_total",
+		Help: "GitHub API calls by endpoint and status.",
+	}, []string{"method", "endpoint", "status"})
+	apiDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "projector_github_api_call_seconds",
+		Help:    "Latency of GitHub API calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+	rateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "projector_github_rate_limit_remaining",
+		Help: "Requests left in the current GitHub rate limit window.",
+	})
+	reportDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "projector_report_generation_seconds",
+		Help:    "Time spent generating the report of a project.",
+		Buckets: []float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"project"})
+)
+
+// matchedRule counts a rule match
+func matchedRule(kind string, name string) {
+	ruleMatches.WithLabelValues(kind, name).Inc()
+}
+
+// metricsTransport records every GitHub API call
+type metricsTransport struct {
+	next http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	endpoint := APIEndpoint(req.URL.Path)
+	rsp, err := t.next.RoundTrip(req)
+	apiDuration.WithLabelValues(req.Method, endpoint).Observe(time.Since(start).Seconds())
+	if err != nil {
+		apiCalls.WithLabelValues(req.Method, endpoint, "error").Inc()
+		return rsp, err
+	}
+	apiCalls.WithLabelValues(req.Method, endpoint, strconv.Itoa(rsp.StatusCode)).Inc()
+	if remaining, err := strconv.Atoi(rsp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		rateLimitRemaining.Set(float64(remaining))
+	}
+	return rsp, err
+}
+
+// APIEndpoint turns a GitHub API path into a route template, replacing IDs
+// and names so every call of an endpoint shares a label
+func APIEndpoint(path string) string {
+	params := map[string][]string{
+		"repos":         {":owner", ":repo"},
+		"orgs":          {":org"},
+		"users":         {":user"},
+		"collaborators": {":user"},
+		"labels":        {":name"},
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments); i++ {
+		if _, err := strconv.ParseInt(segments[i], 10, 64); err == nil {
+			segments[i] = ":id"
+			continue
+		}
+		for _, param := range params[segments[i]] {
+			if i+1 == len(segments) {
+				break
+			}
+			i++
+			segments[i] = param
+		}
+	}
+	return "/" + strings.Join(segments, "/")
+}
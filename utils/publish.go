@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Report sink types
+const (
+	// SinkIssue comments on a GitHub Issue
+	SinkIssue = "issue"
+	// SinkFile writes to a file on disk
+	SinkFile = "file"
+	// SinkWebhook posts a Slack compatible payload to a URL
+	SinkWebhook = "webhook"
+)
+
+// ReportSink defines where scheduled reports get published
+type ReportSink struct {
+	Name string
+	Type string
+	// Repo and Number of the Issue to comment on
+	Repo   string
+	Number int
+	// Path of the file to write
+	Path string
+	// Format of the file, defaults to markdown
+	Format string
+	// URL of the webhook
+	URL string
+}
+
+// Publisher publishes reports to sinks
+type Publisher struct {
+	gh     *GH
+	client *http.Client
+}
+
+// NewPublisher creates a new instance of Publisher
+func NewPublisher(gh *GH) *Publisher {
+	return &Publisher{gh: gh, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// ReportSinks returns the configured report sinks
+func (r *RulesProcessor) ReportSinks() []ReportSink {
+	var sinks []ReportSink
+	if err := r.decodeRules("ReportSinks", &sinks); err != nil {
+		r.log.WithError(err).Error("Error Decoding Report Sinks")
+	}
+	return sinks
+}
+
+// PublishAll publishes reports to every sink, logging failures
+func (p *Publisher) PublishAll(sinks []ReportSink, reports []Report) {
+	for _, s := range sinks {
+		if err := p.Publish(s, reports); err != nil {
+			p.gh.log.WithError(err).WithField("sink", s.Name).Error("Error Publishing Reports")
+			continue
+		}
+		p.gh.log.WithField("sink", s.Name).Info("Published Reports")
+	}
+}
+
+// Publish publishes reports to a sink
+func (p *Publisher) Publish(sink ReportSink, reports []Report) error {
+	switch sink.Type {
+	case SinkIssue:
+		body, err := renderString(MarkdownRenderer{}, reports)
+		if err != nil {
+			return err
+		}
+		return p.gh.CreateComment(sink.Repo, sink.Number, body)
+	case SinkFile:
+		format := sink.Format
+		if format == "" {
+			format = "markdown"
+		}
+		renderer, ok := RendererFor(format, "")
+		if !ok {
+			return fmt.Errorf("unsupported report format %q", format)
+		}
+		body, err := renderString(renderer, reports)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(sink.Path, []byte(body), 0644)
+	case SinkWebhook:
+		text, err := renderString(MarkdownRenderer{}, reports)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(map[string]string{"text": text})
+		if err != nil {
+			return err
+		}
+		rsp, err := p.client.Post(sink.URL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		defer rsp.Body.Close()
+		if rsp.StatusCode >= 300 {
+			return fmt.Errorf("webhook responded %s", rsp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown sink type %q", sink.Type)
+}
+
+func renderString(r Renderer, reports []Report) (string, error) {
+	var b bytes.Buffer
+	if err := r.Render(&b, reports); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrQueueFull rejects deliveries while every queue slot is taken
+var ErrQueueFull = errors.New("webhook queue is full")
+
+// ErrQueueStopped rejects deliveries once the queue is shutting down
+var ErrQueueStopped = errors.New("webhook queue is stopped")
+
+// Job is a webhook delivery waiting to be processed
+type Job struct {
+	DeliveryID string
+	EventType  string
+	Payload    []byte
+	Attempts   int
+	LastError  string
+	// Replayed marks a dead-lettered delivery sent through the queue again
+	Replayed bool
+	// Done are the steps of the delivery that succeeded in earlier attempts
+	Done []string
+}
+
+// Step runs a step of the delivery unless it succeeded in an earlier attempt,
+// so retries don't repeat the comments, cards and replies it made
+func (j *Job) Step(name string, run func() error) error {
+	if contains(j.Done, name) {
+		return nil
+	}
+	if err := run(); err != nil {
+		return err
+	}
+	j.Done = append(j.Done, name)
+	return nil
+}
+
+// Queue processes webhook deliveries in the background, retrying failed ones
+// with exponential backoff until MaxAttempts
+type Queue struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	jobs        chan *Job
+	process     func(*Job) error
+	log         *logrus.Entry
+	// OnGiveUp is called with deliveries that failed MaxAttempts times, or
+	// that were still pending when the queue stopped
+	OnGiveUp func(*Job)
+	// mu guards started, stopped and retries, the deliveries waiting to be
+	// retried
+	mu      sync.Mutex
+	started bool
+	stopped bool
+	retries map[*Job]*time.Timer
+	// pending counts the deliveries queued, running or waiting to be retried
+	pending sync.WaitGroup
+}
+
+// NewQueue creates a queue holding up to size deliveries
+func NewQueue(size int, process func(*Job) error) *Queue {
+	return &Queue{
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		jobs:        make(chan *Job, size),
+		process:     process,
+		log:         newLog(),
+		retries:     map[*Job]*time.Timer{},
+	}
+}
+
+// Start processes deliveries with workers goroutines
+func (q *Queue) Start(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	q.mu.Lock()
+	q.started = true
+	q.mu.Unlock()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range q.jobs {
+				q.run(j)
+			}
+		}()
+	}
+}
+
+// Enqueue adds a delivery to the queue
+func (q *Queue) Enqueue(j *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return ErrQueueStopped
+	}
+	// counted first, a worker may finish it before the send returns
+	q.pending.Add(1)
+	select {
+	case q.jobs <- j:
+		QueueDepth.Inc()
+		return nil
+	default:
+		q.pending.Done()
+		return ErrQueueFull
+	}
+}
+
+// Stop refuses new deliveries and waits for the queued ones to be processed
+// until ctx is done. Deliveries waiting to be retried, failing again or still
+// queued when ctx is done are given up, so they can be replayed after a
+// restart.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	q.stopped = true
+	started := q.started
+	var waiting []*Job
+	for j, t := range q.retries {
+		// a timer that already fired queues its delivery again
+		if t.Stop() {
+			waiting = append(waiting, j)
+			delete(q.retries, j)
+		}
+	}
+	q.mu.Unlock()
+	for _, j := range waiting {
+		q.giveUp(j)
+	}
+	if started {
+		drained := make(chan struct{})
+		go func() {
+			q.pending.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+			return nil
+		case <-ctx.Done():
+		}
+	}
+	// without workers nothing would process the queued deliveries
+	for {
+		select {
+		case j := <-q.jobs:
+			q.giveUp(j)
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// run processes a delivery, scheduling a retry when it fails
+func (q *Queue) run(j *Job) {
+	j.Attempts++
+	err := q.process(j)
+	if err == nil {
+		QueueDepth.Dec()
+		q.pending.Done()
+		return
+	}
+	j.LastError = err.Error()
+	l := q.log.WithError(err).WithFields(logrus.Fields{"delivery": j.DeliveryID, "event": j.EventType, "attempts": j.Attempts})
+	q.mu.Lock()
+	if j.Attempts < q.MaxAttempts && !q.stopped {
+		wait := q.Backoff << uint(j.Attempts-1)
+		l.WithField("wait", wait.String()).Warn("Delivery failed, retrying")
+		q.retries[j] = time.AfterFunc(wait, func() {
+			q.mu.Lock()
+			delete(q.retries, j)
+			q.mu.Unlock()
+			q.jobs <- j
+		})
+		q.mu.Unlock()
+		return
+	}
+	q.mu.Unlock()
+	l.Error("Giving up on delivery")
+	q.giveUp(j)
+}
+
+// giveUp hands a delivery that won't be processed to OnGiveUp
+func (q *Queue) giveUp(j *Job) {
+	QueueDepth.Dec()
+	if q.OnGiveUp != nil {
+		q.OnGiveUp(j)
+	}
+	q.pending.Done()
+}
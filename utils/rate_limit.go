@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// minRateRemaining is the request budget below which calls wait for the reset
+	minRateRemaining = 50
+	// maxRateLimitWait caps how long a single call waits for a rate limit
+	maxRateLimitWait = 15 * time.Minute
+	// secondaryRateLimitWait is how long to wait for a secondary rate limit
+	// GitHub sent no Retry-After for
+	secondaryRateLimitWait = time.Minute
+)
+
+// RateLimitWait returns how long to wait before the next call given the last
+// response, using the Retry-After and rate limit headers GitHub sends
+func RateLimitWait(rsp *http.Response, now time.Time) time.Duration {
+	if rsp == nil {
+		return 0
+	}
+	if s := rsp.Header.Get("Retry-After"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(s); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+	remaining, err := strconv.Atoi(rsp.Header.Get("X-RateLimit-Remaining"))
+	if err == nil && remaining < minRateRemaining {
+		if reset, err := strconv.ParseInt(rsp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if wait := time.Unix(reset, 0).Sub(now); wait > 0 {
+				return wait
+			}
+		}
+	}
+	if isRateLimited(rsp) {
+		return secondaryRateLimitWait
+	}
+	return 0
+}
+
+// isRateLimited checks if GitHub rejected a request for exceeding its primary
+// or secondary rate limits
+func isRateLimited(rsp *http.Response) bool {
+	switch {
+	case rsp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case rsp.StatusCode != http.StatusForbidden:
+		return false
+	case rsp.Header.Get("X-RateLimit-Remaining") == "0", rsp.Header.Get("Retry-After") != "":
+		return true
+	}
+	// secondary rate limits without Retry-After are only told by their message
+	body, err := ioutil.ReadAll(rsp.Body)
+	rsp.Body.Close()
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return err == nil && strings.Contains(strings.ToLower(string(body)), "rate limit")
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scopeGrants are the OAuth scopes projector needs, each with the scopes
+// granting it
+var scopeGrants = map[string][]string{
+	"repo":           {"repo"},
+	"admin:org_hook": {"admin:org_hook"},
+	"read:org":       {"read:org", "write:org", "admin:org"},
+}
+
+// Check is the outcome of a readiness check
+type Check struct {
+	Name  string `json:"Name"`
+	OK    bool   `json:"OK"`
+	Error string `json:"Error,omitempty"`
+}
+
+// NewCheck describes the outcome of a readiness check
+func NewCheck(name string, err error) Check {
+	c := Check{Name: name, OK: err == nil}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// RequiredScopes returns the OAuth scopes the token needs. Repo hooks are
+// covered by the repo scope.
+func (g *GH) RequiredScopes() []string {
+	if g.hookRepos != nil {
+		return []string{"repo", "read:org"}
+	}
+	return []string{"repo", "admin:org_hook", "read:org"}
+}
+
+// MissingScopes returns the required scopes a token with the scopes of an
+// X-OAuth-Scopes header lacks
+func MissingScopes(header string, required []string) []string {
+	granted := map[string]bool{}
+	for _, scope := range strings.Split(header, ",") {
+		granted[strings.TrimSpace(scope)] = true
+	}
+	missing := []string{}
+	for _, scope := range required {
+		ok := false
+		for _, s := range scopeGrants[scope] {
+			ok = ok || granted[s]
+		}
+		if !ok {
+			missing = append(missing, scope)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// CheckToken verifies the token is accepted and has the scopes projector
+// needs. Only classic OAuth tokens report their scopes.
+func (g *GH) CheckToken() error {
+	_, rsp, err := g.c.RateLimits(context.Background())
+	if err != nil {
+		return fmt.Errorf("token rejected: %w", err)
+	}
+	if _, ok := rsp.Header["X-Oauth-Scopes"]; !ok {
+		return nil
+	}
+	if missing := MissingScopes(rsp.Header.Get("X-OAuth-Scopes"), g.RequiredScopes()); len(missing) > 0 {
+		return fmt.Errorf("token lacks the %s scopes", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// CheckDefaultProject verifies the default project and column were found
+func (g *GH) CheckDefaultProject() error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.DefaultProjectID == 0 {
+		return fmt.Errorf("default project %q not loaded", g.DefaultProjectName)
+	}
+	if g.defaultColumnID == 0 {
+		return fmt.Errorf("default column %q not found in project %q", g.defaultColumnName, g.DefaultProjectName)
+	}
+	return nil
+}
+
+// CheckHook verifies the org hook, or the hook of each repo in repo hook mode,
+// exists and is reconciled
+func (g *GH) CheckHook() error {
+	targets := g.hookTargets()
+	if len(targets) == 0 {
+		return errors.New("no repos to install hooks on")
+	}
+	if err := g.checkOrgHook(context.Background()); err != nil {
+		return err
+	}
+	for _, repo := range targets {
+		if err := g.checkHook(repo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GH) checkHook(repo string) error {
+	hooks, err := g.listHooks(context.Background(), repo)
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if h.Config["url"] != g.hookURL {
+			continue
+		}
+		if drift := g.HookDrift(h); len(drift) > 0 {
+			return fmt.Errorf("hook %d of %s differs in %s", h.GetID(), g.hookOwner(repo), strings.Join(drift, ", "))
+		}
+		return nil
+	}
+	return fmt.Errorf("no hook for %s in %s", g.hookURL, g.hookOwner(repo))
+}
+
+// CheckRules verifies the rules config file was loaded
+func (r *RulesProcessor) CheckRules() error {
+	return r.rulesErr
+}
+
+// CheckRuleProjects verifies the projects the rules act on were found in the
+// org
+func (r *RulesProcessor) CheckRuleProjects() error {
+	missing := []string{}
+	for _, name := range r.ruleProjects() {
+		if _, err := r.gh.GetProjectID(name); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("projects %s of the rules not found in org %s", strings.Join(missing, ", "), r.gh.org)
+	}
+	return nil
+}
+
+// ruleProjects lists the projects named by the label, lifecycle, WIP, archive
+// and stale rules
+func (r *RulesProcessor) ruleProjects() []string {
+	var names []string
+	var label []LabelRule
+	var lifecycle []LifecycleRule
+	var wip []WIPLimit
+	var archive []ArchiveRule
+	var stale []StaleRule
+	// undecodable rules are reported when they are applied
+	r.decodeRules("LabelRules", &label)
+	r.decodeRules("LifecycleRules", &lifecycle)
+	r.decodeRules("WIPLimits", &wip)
+	r.decodeRules("ArchiveRules", &archive)
+	r.decodeRules("StaleRules", &stale)
+	for _, rule := range label {
+		names = append(names, rule.Project)
+	}
+	for _, rule := range lifecycle {
+		names = append(names, rule.Project)
+	}
+	for _, l := range wip {
+		names = append(names, l.Project)
+	}
+	for _, rule := range archive {
+		names = append(names, rule.Project)
+	}
+	for _, rule := range stale {
+		names = append(names, rule.Project)
+	}
+	seen := map[string]bool{}
+	projects := []string{}
+	for _, name := range names {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			projects = append(projects, name)
+		}
+	}
+	sort.Strings(projects)
+	return projects
+}
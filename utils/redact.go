@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redacted replaces the values of sensitive settings
+const redacted = "REDACTED"
+
+// sensitive checks if a setting holds a credential. Sink URLs are included as
+// webhook URLs carry their token.
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "token") || strings.Contains(key, "secret") || strings.Contains(key, "password") || key == "url"
+}
+
+// Redact returns a copy of settings with the values of sensitive keys replaced,
+// at any depth
+func Redact(settings map[string]interface{}) map[string]interface{} {
+	return redact(settings).(map[string]interface{})
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = redactValue(key, value)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			m[key] = redactValue(fmt.Sprint(key), value)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = redact(value)
+		}
+		return s
+	case []map[string]interface{}:
+		s := make([]interface{}, len(v))
+		for i, value := range v {
+			s[i] = redact(value)
+		}
+		return s
+	}
+	return v
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if sensitive(key) && value != nil && value != "" {
+		return redacted
+	}
+	return redact(value)
+}
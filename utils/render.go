@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Renderer writes reports in an output format
+type Renderer interface {
+	ContentType() string
+	Render(w io.Writer, reports []Report) error
+}
+
+var renderers = map[string]Renderer{
+	"json":     JSONRenderer{},
+	"csv":      CSVRenderer{},
+	"markdown": MarkdownRenderer{},
+	"html":     HTMLRenderer{},
+}
+
+// RendererFor picks a renderer by format name, falling back to the first
+// supported type of an Accept header and JSON when neither is given
+func RendererFor(format string, accept string) (Renderer, bool) {
+	if format != "" {
+		r, ok := renderers[strings.ToLower(format)]
+		return r, ok
+	}
+	if accept == "" {
+		return renderers["json"], true
+	}
+	for _, a := range strings.Split(accept, ",") {
+		mediaType := strings.TrimSpace(strings.Split(a, ";")[0])
+		if mediaType == "*/*" {
+			return renderers["json"], true
+		}
+		for _, r := range renderers {
+			if r.ContentType() == mediaType {
+				return r, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// JSONRenderer renders reports as JSON
+type JSONRenderer struct{}
+
+// ContentType of JSON reports
+func (JSONRenderer) ContentType() string { return "application/json" }
+
+// Render writes reports as JSON
+func (JSONRenderer) Render(w io.Writer, reports []Report) error {
+	return json.NewEncoder(w).Encode(reports)
+}
+
+// CSVRenderer renders one row per project and label
+type CSVRenderer struct{}
+
+// ContentType of CSV reports
+func (CSVRenderer) ContentType() string { return "text/csv" }
+
+// Render writes reports as CSV
+func (CSVRenderer) Render(w io.Writer, reports []Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"ProjectBoard", "Since", "Until", "IssuesClosed", "Label", "Count"}); err != nil {
+		return err
+	}
+	for _, r := range reports {
+		since, until := reportPeriod(r)
+		row := []string{r.ProjectBoard, since, until, strconv.Itoa(r.IssuesClosed)}
+		if len(r.LabelCounts) == 0 {
+			if err := cw.Write(append(row, "", "")); err != nil {
+				return err
+			}
+		}
+		for _, lc := range sortedLabelCounts(r.LabelCounts) {
+			if err := cw.Write(append(row, *lc.Name, strconv.Itoa(lc.Count))); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// MarkdownRenderer renders a section with tables per project
+type MarkdownRenderer struct{}
+
+// ContentType of Markdown reports
+func (MarkdownRenderer) ContentType() string { return "text/markdown" }
+
+// Render writes reports as Markdown
+func (MarkdownRenderer) Render(w io.Writer, reports []Report) error {
+	var b strings.Builder
+	for _, r := range reports {
+		fmt.Fprintf(&b, "## %s\n\n", r.ProjectBoard)
+		if since, until := reportPeriod(r); since != "" || until != "" {
+			fmt.Fprintf(&b, "Period: %s to %s\n\n", orDash(since), orDash(until))
+		}
+		fmt.Fprintf(&b, "Issues closed: %d\n\n", r.IssuesClosed)
+		if r.Flow != nil {
+			fmt.Fprintf(&b, "Lead time p50: %.1fh, cycle time p50: %.1fh\n\n", r.Flow.LeadTime.P50, r.Flow.CycleTime.P50)
+		}
+		if len(r.LabelCounts) > 0 {
+			b.WriteString("| Label | Count |\n| -- | -- |\n")
+			for _, lc := range sortedLabelCounts(r.LabelCounts) {
+				fmt.Fprintf(&b, "| %s | %d |\n", escapeMarkdown(*lc.Name), lc.Count)
+			}
+			b.WriteString("\n")
+		}
+		if len(r.WIP) > 0 {
+			b.WriteString("| Column | WIP | Limit |\n| -- | -- | -- |\n")
+			for _, wip := range r.WIP {
+				fmt.Fprintf(&b, "| %s | %d | %d |\n", escapeMarkdown(wip.Column), wip.Count, wip.Limit)
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// HTMLRenderer renders a self contained dashboard with label charts
+type HTMLRenderer struct{}
+
+// ContentType of HTML reports
+func (HTMLRenderer) ContentType() string { return "text/html" }
+
+// htmlBar is a single label bar of the HTML chart
+type htmlBar struct {
+	Name    string
+	Count   int
+	Percent int
+}
+
+var dashboard = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Projector Reports</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #24292e; }
+section { margin-bottom: 2em; }
+.row { display: flex; align-items: center; margin: 2px 0; }
+.name { width: 14em; }
+.bar { background: #0366d6; color: #fff; padding: 2px 4px; min-width: 1.5em; }
+</style>
+</head>
+<body>
+<h1>Projector Reports</h1>
+{{range .}}<section>
+<h2>{{.Report.ProjectBoard}}</h2>
+<p>Issues closed: {{.Report.IssuesClosed}}</p>
+{{range .Bars}}<div class="row"><span class="name">{{.Name}}</span><span class="bar" style="width: {{.Percent}}%">{{.Count}}</span></div>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+// Render writes reports as an HTML page
+func (HTMLRenderer) Render(w io.Writer, reports []Report) error {
+	type section struct {
+		Report Report
+		Bars   []htmlBar
+	}
+	var sections []section
+	for _, r := range reports {
+		max := 0
+		for _, lc := range r.LabelCounts {
+			if lc.Count > max {
+				max = lc.Count
+			}
+		}
+		s := section{Report: r}
+		for _, lc := range sortedLabelCounts(r.LabelCounts) {
+			s.Bars = append(s.Bars, htmlBar{Name: *lc.Name, Count: lc.Count, Percent: lc.Count * 60 / max})
+		}
+		sections = append(sections, s)
+	}
+	return dashboard.Execute(w, sections)
+}
+
+// sortedLabelCounts orders label counts by count, then name
+func sortedLabelCounts(counts []*LabelCount) []*LabelCount {
+	sorted := append([]*LabelCount(nil), counts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return *sorted[i].Name < *sorted[j].Name
+	})
+	return sorted
+}
+
+func reportPeriod(r Report) (string, string) {
+	var since, until string
+	if r.Since != nil {
+		since = r.Since.Format("2006-01-02")
+	}
+	if r.Until != nil {
+		until = r.Until.Format("2006-01-02")
+	}
+	return since, until
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func escapeMarkdown(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
@@ -2,27 +2,70 @@ package utils
 
 import (
 	"errors"
-	"log"
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
 )
 
 // Reporter stores all reports and metadata
 type Reporter struct {
 	Reports []Report `json:"Reports"`
 	GH      *GH
+	// IncludeArchived adds archived cards to reports
+	IncludeArchived bool
+	WIPLimits       []WIPLimit
+	// Window limits reports to items closed in a period
+	Window ReportWindow
+	// History provides card movements for flow metrics
+	History *CardHistory
+	// CycleStartColumn is where cycle time starts counting
+	CycleStartColumn string
+	LabelGroups      []LabelGroup
+	// Period is the length of each velocity period
+	Period time.Duration
+	// Concurrency limits how many projects are reported on at once
+	Concurrency int
+	// Dimensions adds breakdowns by assignee, author, repository or milestone
+	Dimensions []string
+	// DoneColumns overrides DefaultDoneColumns per project
+	DoneColumns        map[string][]string
+	DefaultDoneColumns []string
+	// Snapshot adds the cards of every column to reports
+	Snapshot bool
+	log      *logrus.Entry
+	mu       sync.Mutex
+	issues   map[string]*github.Issue
+	// prefetched holds how far back the closed Issues of each repo were listed
+	prefetched map[string]time.Time
 }
 
+// batchThreshold is the number of cards of a repo from which its closed
+// Issues are listed page by page instead of fetched one at a time
+const batchThreshold = 10
+
 // Report shows all stats based on a project
 type Report struct {
-	ProjectBoard string `json:"ProjectBoard"`
-	IssuesClosed int    `json:"IssuesClosed"`
+	ProjectBoard string     `json:"ProjectBoard"`
+	Since        *time.Time `json:"Since,omitempty"`
+	Until        *time.Time `json:"Until,omitempty"`
+	IssuesClosed int        `json:"IssuesClosed"`
 	//PRsClosed    int           `json:"PRsClosed"`
-	LabelCounts  []*LabelCount `json:"LabelCounts"`
-	ProjectCards []*Card       `json:"ProjectCards"`
+	LabelCounts  []*LabelCount     `json:"LabelCounts"`
+	ProjectCards []*Card           `json:"ProjectCards"`
+	WIP          []*ColumnWIP      `json:"WIP,omitempty"`
+	Flow         *FlowMetrics      `json:"Flow,omitempty"`
+	StoryPoints  float64           `json:"StoryPoints,omitempty"`
+	LabelGroups  []*GroupCount     `json:"LabelGroups,omitempty"`
+	Velocity     []*PeriodVelocity `json:"Velocity,omitempty"`
+	Breakdowns   []*Breakdown      `json:"Breakdowns,omitempty"`
+	Columns      []*ColumnSnapshot `json:"Columns,omitempty"`
+	// Error is set when the report of the project could not be generated
+	Error string `json:"Error,omitempty"`
 }
 
 // Card extends github cards to store type
@@ -34,6 +77,11 @@ type Card struct {
 	Name           string          `json:"Name"`
 	PullRequestURL *string         `json:"PullRequestURL,omitempty"`
 	Labels         []*github.Label `json:"Labels,omitempty"`
+	Author         string          `json:"Author,omitempty"`
+	Assignees      []string        `json:"Assignees,omitempty"`
+	Milestone      string          `json:"Milestone,omitempty"`
+	OpenedAt       *time.Time      `json:"OpenedAt,omitempty"`
+	ClosedAt       *time.Time      `json:"ClosedAt,omitempty"`
 }
 
 // LabelCount keeps  track of all issues with labels
@@ -42,58 +90,188 @@ type LabelCount struct {
 	Count int     `json:"Count,omitempty"`
 }
 
-// NewReporter creates a new instance of Reporter
-func NewReporter() *Reporter {
+// NewReporter creates a new instance of Reporter, sharing gh's client, failed
+// requests and projects
+func NewReporter(gh *GH) *Reporter {
 	r := Reporter{
-		GH: NewGH(),
+		GH:                 gh,
+		log:                newLog(),
+		History:            NewCardHistory(nil),
+		CycleStartColumn:   "In Progress",
+		Period:             7 * 24 * time.Hour,
+		Concurrency:        4,
+		DefaultDoneColumns: []string{"Done"},
+		issues:             map[string]*github.Issue{},
+		prefetched:         map[string]time.Time{},
 	}
 	return &r
 }
 
-// GenerateReports calls necessary functions to complete a report
+// GenerateReports calls necessary functions to complete a report, working on
+// at most Concurrency projects at a time
 func (r *Reporter) GenerateReports(projects []*github.Project) {
-	log.Println("Reports Generating...")
+	r.log.WithField("projects", len(projects)).Info("Reports Generating")
 	var wg sync.WaitGroup
-
-	for _, p := range projects {
+	jobs := make(chan *github.Project)
+	workers := r.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
 		wg.Add(1)
-		go r.GenerateReport(p, &wg)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				report, err := r.GenerateReport(p)
+				if err != nil {
+					r.log.WithError(err).WithField("project", p.GetName()).Error("Error Generating Report")
+					report = Report{ProjectBoard: p.GetName(), Error: err.Error()}
+				}
+				r.mu.Lock()
+				r.Reports = append(r.Reports, report)
+				r.mu.Unlock()
+			}
+		}()
 	}
+	for _, p := range projects {
+		jobs <- p
+	}
+	close(jobs)
 	wg.Wait()
 }
 
 // GenerateReport calls necessary functions to complete a report
-func (r *Reporter) GenerateReport(project *github.Project, wg *sync.WaitGroup) {
-	cardsDone := r.GetProjectCardsFromColumn(*project.ID, "Done")
+func (r *Reporter) GenerateReport(project *github.Project) (Report, error) {
+	start := time.Now()
+	defer func() { reportDuration.WithLabelValues(project.GetName()).Observe(time.Since(start).Seconds()) }()
+	var cardsDone []*github.ProjectCard
+	for _, column := range r.doneColumns(*project.Name) {
+		cards, err := r.GetProjectCardsFromColumn(*project.ID, column)
+		if err != nil {
+			return Report{}, err
+		}
+		cardsDone = append(cardsDone, cards...)
+	}
 	cardsWithMetadata := r.GetContentTypes(cardsDone)
+	issuesClosed := len(cardsDone)
+	if !r.Window.IsZero() {
+		cardsWithMetadata = r.FilterCards(cardsWithMetadata)
+		issuesClosed = len(cardsWithMetadata)
+	}
+	wip, err := r.GetColumnWIP(project)
+	if err != nil {
+		return Report{}, err
+	}
+	flow, err := r.GetFlowMetrics(project, cardsWithMetadata)
+	if err != nil {
+		return Report{}, err
+	}
 	report := Report{
 		ProjectBoard: *project.Name,
-		IssuesClosed: len(cardsDone),
+		IssuesClosed: issuesClosed,
 		LabelCounts:  r.GetLabelCount(cardsWithMetadata),
 		ProjectCards: cardsWithMetadata,
+		WIP:          wip,
+		Flow:         flow,
+	}
+	if len(r.LabelGroups) > 0 {
+		report.LabelGroups = GroupLabels(r.LabelGroups, cardsWithMetadata)
+		for _, c := range cardsWithMetadata {
+			report.StoryPoints += StoryPoints(r.LabelGroups, c)
+		}
+		report.Velocity = r.GetVelocity(cardsWithMetadata)
+	}
+	if len(r.Dimensions) > 0 {
+		open, err := r.GetOpenCards(project)
+		if err != nil {
+			return Report{}, err
+		}
+		report.Breakdowns = r.BreakdownCards(r.Dimensions, cardsWithMetadata, open)
+	}
+	if r.Snapshot {
+		if report.Columns, err = r.GetSnapshot(project, time.Now()); err != nil {
+			return Report{}, err
+		}
 	}
-	log.Println("Processing report for", *project.Name)
-	r.Reports = append(r.Reports, report)
-	defer wg.Done()
+	if !r.Window.Since.IsZero() {
+		report.Since = &r.Window.Since
+	}
+	if !r.Window.Until.IsZero() {
+		report.Until = &r.Window.Until
+	}
+	r.log.WithField("project", *project.Name).Debug("Processing report")
+	return report, nil
+}
+
+// GetColumnWIP counts the cards of every column with a WIP limit
+func (r *Reporter) GetColumnWIP(project *github.Project) ([]*ColumnWIP, error) {
+	var wip []*ColumnWIP
+	var cols []*github.ProjectColumn
+	for _, l := range r.WIPLimits {
+		if l.Project != *project.Name {
+			continue
+		}
+		if cols == nil {
+			var err error
+			if cols, err = r.GH.ListProjectColumns(*project.ID); err != nil {
+				return nil, err
+			}
+		}
+		if colID, ok := r.GH.GetCardColumnIDByName(cols, l.Column); ok {
+			cards, err := r.GH.ListProjectCards(colID)
+			if err != nil {
+				return nil, err
+			}
+			wip = append(wip, &ColumnWIP{Column: l.Column, Count: len(cards), Limit: l.Limit})
+		}
+	}
+	return wip, nil
+}
+
+// GetFlowMetrics computes lead, cycle and time in column metrics of cards
+// from their recorded history, falling back to their Issue timeline
+func (r *Reporter) GetFlowMetrics(project *github.Project, cards []*Card) (*FlowMetrics, error) {
+	cols, err := r.GH.ListProjectColumns(*project.ID)
+	if err != nil {
+		return nil, err
+	}
+	columns := map[int64]string{}
+	for _, c := range cols {
+		columns[c.GetID()] = c.GetName()
+	}
+	flows := make([]CardFlow, len(cards))
+	for i, c := range cards {
+		events := r.History.Events(c.GetID())
+		if len(events) == 0 && c.Repo != "" {
+			events = TimelineCardEvents(r.GH.ListIssueTimeline(c.Repo, c.Number), c.GetID())
+			r.History.Add(events...)
+		}
+		flows[i] = ComputeCardFlow(events, columns, c.OpenedAt, c.ClosedAt, r.CycleStartColumn)
+	}
+	return SummarizeFlows(cards, flows), nil
 }
 
 // GetProjectCardsFromColumn Gets all the cards for a Project
-func (r *Reporter) GetProjectCardsFromColumn(projID int64, column string) []*github.ProjectCard {
-	cols := r.GH.ListProjectColumns(projID)
-	var cards []*github.ProjectCard
-	if colID, ok := r.GH.GetCardColumnIDByName(cols, column); ok {
-		//the value exists
-		cards = r.GH.ListProjectCards(colID)
-	} else {
-		log.Print("Unable to get Column ID")
-		return nil
+func (r *Reporter) GetProjectCardsFromColumn(projID int64, column string) ([]*github.ProjectCard, error) {
+	cols, err := r.GH.ListProjectColumns(projID)
+	if err != nil {
+		return nil, err
+	}
+	colID, ok := r.GH.GetCardColumnIDByName(cols, column)
+	if !ok {
+		r.log.WithField("column", column).Warn("Unable to get Column ID")
+		return nil, nil
+	}
+	if r.IncludeArchived {
+		return r.GH.ListProjectCardsByState(colID, "all")
 	}
-	return cards
+	return r.GH.ListProjectCards(colID)
 }
 
 // GetContentTypes figures out if PR or Issue
 func (r *Reporter) GetContentTypes(cards []*github.ProjectCard) []*Card {
 	cardsWithType := []*Card{}
+	r.prefetchIssues(cards)
 	for _, c := range cards {
 		c.Creator = &github.User{Name: c.Creator.Name}
 		var card = Card{ProjectCard: *c}
@@ -101,17 +279,14 @@ func (r *Reporter) GetContentTypes(cards []*github.ProjectCard) []*Card {
 			var card = Card{ProjectCard: *c}
 			repo, contentType, number, err := r.StripContentURL(*c.ContentURL)
 			if err != nil {
-				log.Printf("Error Parsing URL")
+				r.log.WithError(err).WithField("url", *card.ContentURL).Warn("Error Parsing URL")
+				cardsWithType = append(cardsWithType, &card)
+				continue
 			}
 			card.Number = *number
 			card.Repo = *repo
 			if issue, ok := r.isIssue(&card); ok {
-				card.ContentType = *contentType
-				card.Name = *issue.Title
-				card.Labels = issue.Labels
-				if issue.PullRequestLinks != nil {
-					card.PullRequestURL = issue.PullRequestLinks.URL
-				}
+				describeCard(&card, *contentType, issue)
 			}
 			cardsWithType = append(cardsWithType, &card)
 		} else {
@@ -121,15 +296,175 @@ func (r *Reporter) GetContentTypes(cards []*github.ProjectCard) []*Card {
 	return cardsWithType
 }
 
+// GetOpenCards gets the cards of open Issues and PRs outside the done columns
+func (r *Reporter) GetOpenCards(project *github.Project) ([]*Card, error) {
+	cols, err := r.GH.ListProjectColumns(*project.ID)
+	if err != nil {
+		return nil, err
+	}
+	var open []*Card
+	for _, col := range cols {
+		if r.isDone(project.GetName(), col.GetName()) {
+			continue
+		}
+		cards, err := r.GH.ListProjectCards(col.GetID())
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cards {
+			if c.ContentURL == nil {
+				continue
+			}
+			repo, contentType, number, err := r.StripContentURL(*c.ContentURL)
+			if err != nil {
+				continue
+			}
+			card := Card{ProjectCard: *c, Repo: *repo, Number: *number}
+			if issue := r.lookupIssue(&card); issue != nil && issue.GetState() == "open" {
+				describeCard(&card, *contentType, issue)
+				open = append(open, &card)
+			}
+		}
+	}
+	return open, nil
+}
+
+// describeCard copies the metadata of its Issue or PR onto a card
+func describeCard(card *Card, contentType string, issue *github.Issue) {
+	card.ContentType = contentType
+	card.Name = issue.GetTitle()
+	card.Labels = issue.Labels
+	card.Author = issue.GetUser().GetLogin()
+	for _, a := range issue.Assignees {
+		card.Assignees = append(card.Assignees, a.GetLogin())
+	}
+	card.Milestone = issue.GetMilestone().GetTitle()
+	card.OpenedAt = issue.CreatedAt
+	card.ClosedAt = issue.ClosedAt
+	if issue.PullRequestLinks != nil {
+		card.PullRequestURL = issue.PullRequestLinks.URL
+	}
+}
+
+// FilterCards keeps the cards closed inside the report window. Cards without
+// a closed Issue fall back to the time they were last moved.
+func (r *Reporter) FilterCards(cards []*Card) []*Card {
+	filtered := []*Card{}
+	for _, c := range cards {
+		if closed, ok := cardClosedAt(c); ok && r.Window.Contains(closed) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// GetVelocity buckets cards into periods over the report window, starting at
+// the first closed card when the window has no start
+func (r *Reporter) GetVelocity(cards []*Card) []*PeriodVelocity {
+	since, until := r.Window.Since, r.Window.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if since.IsZero() {
+		for _, c := range cards {
+			if closed, ok := cardClosedAt(c); ok && (since.IsZero() || closed.Before(since)) {
+				since = closed
+			}
+		}
+		if since.IsZero() {
+			return nil
+		}
+		// an open window reports on the most recent periods only
+		if oldest := until.Add(-maxVelocityPeriods * r.Period); since.Before(oldest) {
+			since = oldest
+		}
+	}
+	return Velocity(r.LabelGroups, cards, since, until, r.Period)
+}
+
+// cardClosedAt returns when the card's Issue was closed, falling back to when
+// the card was last moved
+func cardClosedAt(c *Card) (time.Time, bool) {
+	switch {
+	case c.ClosedAt != nil:
+		return *c.ClosedAt, true
+	case c.UpdatedAt != nil:
+		return c.UpdatedAt.Time, true
+	}
+	return time.Time{}, false
+}
 
 func (r *Reporter) isIssue(card *Card) (*github.Issue, bool) {
-	i, _ := r.GH.GetIssue(card.Repo, card.Number)
+	i := r.lookupIssue(card)
 	if i != nil && *i.State == "closed" {
 		return i, true
 	}
 	return nil, false
 }
 
+// lookupIssue gets the Issue or PR of a card, from the cache when possible
+func (r *Reporter) lookupIssue(card *Card) *github.Issue {
+	key := fmt.Sprintf("%s#%d", card.Repo, card.Number)
+	r.mu.Lock()
+	i, ok := r.issues[key]
+	r.mu.Unlock()
+	if !ok {
+		i, _ = r.GH.GetIssue(card.Repo, card.Number)
+		r.cacheIssue(card.Repo, i)
+	}
+	return i
+}
+
+// prefetchIssues lists the closed Issues of repos with many cards in one go
+// so they don't have to be fetched one at a time. Without a since, Issues
+// updated since the oldest card of the repo was created are listed rather than
+// every closed Issue of the repo; the few missed are fetched one by one.
+func (r *Reporter) prefetchIssues(cards []*github.ProjectCard) {
+	perRepo := map[string]int{}
+	oldest := map[string]time.Time{}
+	for _, c := range cards {
+		if c.ContentURL == nil {
+			continue
+		}
+		repo, _, ok := ParseContentURL(*c.ContentURL)
+		if !ok {
+			continue
+		}
+		perRepo[repo]++
+		if created := c.GetCreatedAt().Time; oldest[repo].IsZero() || created.Before(oldest[repo]) {
+			oldest[repo] = created
+		}
+	}
+	for repo, n := range perRepo {
+		since := r.Window.Since
+		if since.IsZero() {
+			since = oldest[repo]
+		}
+		r.mu.Lock()
+		listed, ok := r.prefetched[repo]
+		skip := n < batchThreshold || since.IsZero() || (ok && !listed.After(since))
+		if !skip {
+			r.prefetched[repo] = since
+		}
+		r.mu.Unlock()
+		if skip {
+			continue
+		}
+		for _, i := range r.GH.ListRepoIssues(repo, "closed", since) {
+			r.cacheIssue(repo, i)
+		}
+	}
+}
+
+func (r *Reporter) cacheIssue(repo string, i *github.Issue) {
+	if i == nil {
+		return
+	}
+	r.mu.Lock()
+	r.issues[fmt.Sprintf("%s#%d", repo, i.GetNumber())] = i
+	r.mu.Unlock()
+}
+
 // Get All Project Labels, then for each label found increase count
 
 // GetLabelCount does all the summation of labels
@@ -160,7 +495,7 @@ func (r *Reporter) AppendIfMissing(slice []*LabelCount, i *LabelCount) []*LabelC
 
 // StripContentURL strips an api url for data
 func (r *Reporter) StripContentURL(s string) (*string, *string, *int, error) {
-	stripedContent := strings.Replace(s, "https://api.github.com/repos/"+r.GH.org+"/", "", -1)
+	stripedContent := strings.Replace(s, r.GH.c.BaseURL.String()+"repos/"+r.GH.org+"/", "", -1)
 	sc := strings.Split(stripedContent, "/")
 	repo := sc[0]
 	contentType := sc[1]
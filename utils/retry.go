@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRetries is how often a failed GitHub request is retried
+	maxRetries = 3
+	// retryBaseDelay is the backoff before the first retry
+	retryBaseDelay = 500 * time.Millisecond
+	// retryMaxDelay caps the backoff between retries
+	retryMaxDelay = 30 * time.Second
+	// maxFailedRequests is how many failed requests are kept
+	maxFailedRequests = 100
+)
+
+// safeMutations are the mutations that leave the same state when repeated
+var safeMutations = map[string]bool{
+	"PATCH /projects/columns/cards/:id":                      true,
+	"POST /projects/columns/cards/:id/moves":                 true,
+	"POST /projects/columns/:id/cards":                       true, // a duplicate is a 422 CreateProjectCard takes as created
+	"POST /repos/:owner/:repo/issues/:id/labels":             true,
+	"POST /repos/:owner/:repo/issues/comments/:id/reactions": true,
+}
+
+// FailedRequest is a GitHub request that failed for good
+type FailedRequest struct {
+	Method   string    `json:"Method"`
+	Endpoint string    `json:"Endpoint"`
+	URL      string    `json:"URL"`
+	Status   int       `json:"Status,omitempty"`
+	Error    string    `json:"Error,omitempty"`
+	Attempts int       `json:"Attempts"`
+	At       time.Time `json:"At"`
+}
+
+// FailedRequests is a dead-letter list of the most recent GitHub requests
+// that failed after their retries or could not be retried
+type FailedRequests struct {
+	mu       sync.Mutex
+	requests []FailedRequest
+}
+
+func (f *FailedRequests) add(r FailedRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+	if len(f.requests) > maxFailedRequests {
+		f.requests = f.requests[len(f.requests)-maxFailedRequests:]
+	}
+}
+
+// List returns the failed requests, most recent first
+func (f *FailedRequests) List() []FailedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	list := make([]FailedRequest, len(f.requests))
+	for i, r := range f.requests {
+		list[len(list)-1-i] = r
+	}
+	return list
+}
+
+// retryTransport retries GitHub requests rejected by rate limits, and
+// idempotent requests or safe mutations that failed with a server or network
+// error, backing off exponentially with jitter
+type retryTransport struct {
+	next   http.RoundTripper
+	failed *FailedRequests
+	log    *logrus.Entry
+	sleep  func(context.Context, time.Duration) error
+	mu     sync.Mutex
+	// throttled delays requests while the rate limit is almost used up
+	throttled time.Time
+}
+
+// NewRetryTransport wraps next with retries, recording permanent failures in failed
+func NewRetryTransport(next http.RoundTripper, failed *FailedRequests) http.RoundTripper {
+	return &retryTransport{next: next, failed: failed, log: newLog(), sleep: sleep}
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.throttle(req.Context()); err != nil {
+		return nil, err
+	}
+	endpoint := APIEndpoint(req.URL.Path)
+	for attempt := 0; ; attempt++ {
+		try := req
+		if attempt > 0 {
+			var err error
+			if try, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+		rsp, err := t.next.RoundTrip(try)
+		if err == nil {
+			t.observe(rsp)
+		}
+		wait, retry := retryWait(req, rsp, err, attempt)
+		if retry && attempt < maxRetries {
+			t.log.WithFields(logrus.Fields{"method": req.Method, "endpoint": endpoint, "attempt": attempt + 1, "wait": wait.String()}).Warn("Retrying GitHub request")
+			if rsp != nil {
+				io.Copy(ioutil.Discard, rsp.Body)
+				rsp.Body.Close()
+			}
+			if err := t.sleep(req.Context(), wait); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if retry || err != nil || rsp.StatusCode >= 500 {
+			f := FailedRequest{Method: req.Method, Endpoint: endpoint, URL: req.URL.String(), Attempts: attempt + 1, At: time.Now()}
+			if err != nil {
+				f.Error = err.Error()
+			} else {
+				f.Status = rsp.StatusCode
+			}
+			t.failed.add(f)
+		}
+		return rsp, err
+	}
+}
+
+// retryWait decides whether a request is retried and after how long
+func retryWait(req *http.Request, rsp *http.Response, err error, attempt int) (time.Duration, bool) {
+	switch {
+	case err != nil:
+		return backoff(attempt), replayable(req)
+	case isRateLimited(rsp):
+		// rejected requests were never applied and can always be retried
+		wait := RateLimitWait(rsp, time.Now())
+		return wait, wait <= maxRateLimitWait
+	case rsp.StatusCode == http.StatusBadGateway, rsp.StatusCode == http.StatusServiceUnavailable,
+		rsp.StatusCode == http.StatusGatewayTimeout, rsp.StatusCode == http.StatusInternalServerError:
+		wait := RateLimitWait(rsp, time.Now())
+		if wait == 0 {
+			wait = backoff(attempt)
+		}
+		return wait, replayable(req)
+	}
+	return 0, false
+}
+
+// backoff returns the jittered exponential delay before a retry
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// replayable checks if a request can be sent again without side effects
+func replayable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		if !safeMutations[req.Method+" "+APIEndpoint(req.URL.Path)] {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewind copies a request with a fresh body for another attempt
+func rewind(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// observe starts throttling when the rate limit is almost used up
+func (t *retryTransport) observe(rsp *http.Response) {
+	if rsp.StatusCode >= 400 {
+		return
+	}
+	if wait := RateLimitWait(rsp, time.Now()); wait > 0 {
+		t.mu.Lock()
+		t.throttled = time.Now().Add(wait)
+		t.mu.Unlock()
+	}
+}
+
+// throttle waits for the rate limit reset when the budget is almost used up
+func (t *retryTransport) throttle(ctx context.Context) error {
+	t.mu.Lock()
+	wait := time.Until(t.throttled)
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		wait = maxRateLimitWait
+	}
+	t.log.WithField("wait", wait.String()).Warn("Rate limit almost used up, waiting")
+	return t.sleep(ctx, wait)
+}
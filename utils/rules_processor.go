@@ -1,12 +1,16 @@
 package utils
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	github "github.com/google/go-github/v32/github"
 	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
@@ -14,7 +18,18 @@ import (
 type RulesProcessor struct {
 	gh         *GH
 	rc         *viper.Viper
+	rcMu       *sync.RWMutex
+	log        *logrus.Entry
 	LabelRules []LabelRule
+	mu         sync.Mutex
+	// ArchiveRuns records every run of ArchiveStaleCards
+	ArchiveRuns []ArchiveRun
+	// rulesErr is why the rules config couldn't be read
+	rulesErr error
+	// store persists archive runs and nudges when set
+	store *Store
+	// nudges records when stale rules acted on cards without a store
+	nudges map[string]time.Time
 }
 
 // LabelRule defines rules based on labels
@@ -28,116 +43,182 @@ type LabelRule struct {
 	Project     string
 }
 
-// NewRulesProcessor creates new metadata object of Rules
-func NewRulesProcessor() *RulesProcessor {
+// NewRulesProcessor creates new metadata object of Rules, sharing gh's client,
+// failed requests and projects
+func NewRulesProcessor(gh *GH) *RulesProcessor {
 	r := RulesProcessor{
-		rc: viper.New(),
-		gh: NewGH(),
+		rc:   viper.New(),
+		rcMu: &sync.RWMutex{},
+		gh:   gh,
+		log:  newLog(),
 	}
 	r.LoadRulesConfig()
 	return &r
 }
 
+// SetStore persists archive runs and stale card nudges in store, which may be
+// nil
+func (r *RulesProcessor) SetStore(store *Store) {
+	r.store = store
+}
+
 // LoadRulesConfig so we can process all the rules
 func (r *RulesProcessor) LoadRulesConfig() {
-	log.Println("Loading rules config...")
+	r.log.Debug("Loading rules config")
 	r.rc.SetConfigName(".prj") // name of config file (without extension)
 	r.rc.SetConfigType("yaml")
 	r.rc.AddConfigPath("/etc/config/")
 	r.rc.AddConfigPath("$HOME/")
 	r.rc.AddConfigPath(".")
 	err := r.rc.ReadInConfig() // Find and read the config file
-	if err != nil {            // Handle errors reading the config file
-		log.Println("Error reading config file:", err)
+	r.rulesErr = err
+	if err != nil { // Handle errors reading the config file
+		r.log.WithError(err).Error("Error reading config file")
 	} else {
-		log.Print("Loaded Rules Config")
+		r.log.WithField("file", r.rc.ConfigFileUsed()).Info("Loaded Rules Config")
+	}
+}
+
+// decodeRules decodes a list of rules from the rules config, leaving out
+// untouched when the key is not configured
+func (r *RulesProcessor) decodeRules(key string, out interface{}) error {
+	r.rcMu.RLock()
+	rules := r.rc.Get(key)
+	r.rcMu.RUnlock()
+	if rules == nil {
+		return nil
 	}
+	return mapstructure.Decode(rules, out)
 }
 
 // MatchesPRRuleConditions make sure the rule has all its conditions met
 func (r *RulesProcessor) MatchesPRRuleConditions(rule LabelRule, e *github.PullRequestEvent) bool {
 	if !strings.Contains(reflect.TypeOf(e).String(), rule.Content) {
-		log.Println("Content Type Condition Check Failed", rule)
+		r.log.WithField("rule", rule.Name).Debug("Content Type Condition Check Failed")
 		return false
 	}
 	if *e.PullRequest.State != rule.State {
-		log.Println("State Condition Check Failed")
+		r.log.WithField("rule", rule.Name).Debug("State Condition Check Failed")
 		return false
 	}
 	if e.Label == nil || rule.Label != *e.Label.Name {
-		log.Println("Label Condition Check Failed", rule)
+		r.log.WithField("rule", rule.Name).Debug("Label Condition Check Failed")
 		return false
 	}
-	log.Println("All Condition Checks Passed!!")
+	r.log.WithField("rule", rule.Name).Debug("All Condition Checks Passed")
 	return true
 }
 
 // MatchesIssueConditions make sure the rule has all its conditions met
 func (r *RulesProcessor) MatchesIssueConditions(rule LabelRule, e *github.IssuesEvent) bool {
 	if !strings.Contains(reflect.TypeOf(e).String(), rule.Content) {
-		log.Println("Content Type Condition Check Failed", rule)
+		r.log.WithField("rule", rule.Name).Debug("Content Type Condition Check Failed")
 		return false
 	}
 	if *e.Issue.State != rule.State {
-		log.Println("State Condition Check Failed", rule)
+		r.log.WithField("rule", rule.Name).Debug("State Condition Check Failed")
 		return false
 	}
 	if e.Label == nil || rule.Label != *e.Label.Name {
-		log.Println("Label Condition Check Failed", rule)
+		r.log.WithField("rule", rule.Name).Debug("Label Condition Check Failed")
 		return false
 	}
-	log.Println("All Condition Checks Passed!!", rule)
+	r.log.WithField("rule", rule.Name).Debug("All Condition Checks Passed")
 	return true
 }
 
 // ProcessLabelRules so we can automate the things
-func (r *RulesProcessor) ProcessLabelRules(e interface{}) {
-	labelRules := r.rc.Get("LabelRules")
-	lRMap := labelRules.([]interface{})
-	err := mapstructure.Decode(lRMap, &r.LabelRules)
+func (r *RulesProcessor) ProcessLabelRules(e interface{}) error {
+	err := r.decodeRules("LabelRules", &r.LabelRules)
 	if err != nil {
-		log.Print("Error Decoding Mapstructure")
+		return fmt.Errorf("decoding label rules: %w", err)
 	}
-	log.Print("Found Rules", r.LabelRules)
+	r.log.WithField("rules", len(r.LabelRules)).Debug("Found Rules")
+	var errs ruleErrors
 	switch e := e.(type) {
 	case *github.PullRequestEvent:
-		log.Print("received a PR to process label rules")
 		for _, rule := range r.LabelRules {
 			if r.MatchesPRRuleConditions(rule, e) {
-				log.Print("Found a Rule that Matches an Event Condition")
-				projID := r.gh.GetProjectID(rule.Project)
-				columns := r.gh.ListProjectColumns(*projID)
-				if colID, ok := r.gh.GetCardColumnIDByName(columns, rule.Column); ok {
-					//the value exists
-					r.gh.CreateProjectCard(rule.Content, *e.PullRequest.ID, colID)
-				} else {
-					log.Print("Unable to get Column ID")
-				}
+				r.log.WithField("rule", rule.Name).Info("Label rule matched")
+				matchedRule("label", rule.Name)
+				errs = errs.add(rule.Name, r.placeCard(rule, *e.PullRequest.ID, nil))
 			}
 		}
 	case *github.IssuesEvent:
-		log.Print("received an Issue to process label rules")
 		if *e.Action != "labeled" && *e.Action != "unlabeled" {
-			log.Println("Ignoring issue action", *e.Action)
-			return
+			r.log.WithField("action", *e.Action).Debug("Ignoring issue action")
+			return nil
 		}
 		for _, rule := range r.LabelRules {
 			if r.MatchesIssueConditions(rule, e) {
-				log.Println("Found a Rule that Matches an Event Condition")
-				projID := r.gh.GetProjectID(rule.Project)
-				columns := r.gh.ListProjectColumns(*projID)
-
-				if colID, ok := r.gh.GetCardColumnIDByName(columns, rule.Column); ok {
-					//the value exists
-					if *e.Action == "labeled" {
-						r.gh.CreateProjectCard(rule.Content, *e.Issue.ID, colID)
-					} else {
-						r.gh.DeleteProjectIssueCard(rule.Content, *e.Issue, *e.Repo.Name, rule.Project)
-					}
+				r.log.WithField("rule", rule.Name).Info("Label rule matched")
+				matchedRule("label", rule.Name)
+				if *e.Action == "labeled" {
+					errs = errs.add(rule.Name, r.placeCard(rule, *e.Issue.ID, nil))
 				} else {
-					log.Print("Unable to get Column ID")
+					errs = errs.add(rule.Name, r.gh.DeleteProjectIssueCard(rule.Content, *e.Issue, *e.Repo.Name, rule.Project))
 				}
 			}
 		}
 	}
+	return errs.err()
+}
+
+// ruleErrors collects the failures of the rules applied to an event
+type ruleErrors []error
+
+// add records the error of a rule. Moves refused by a WIP limit are intended
+// and not failures.
+func (e ruleErrors) add(rule string, err error) ruleErrors {
+	if err == nil || errors.Is(err, ErrWIPLimit) {
+		return e
+	}
+	return append(e, fmt.Errorf("rule %q: %w", rule, err))
+}
+
+func (e ruleErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// err returns nil when no rule failed
+func (e ruleErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// placeCard puts content in the rule's project column. An existing card is
+// moved there instead of creating a new one.
+func (r *RulesProcessor) placeCard(rule LabelRule, contentID int64, card *github.ProjectCard) error {
+	projID, err := r.gh.GetProjectID(rule.Project)
+	if err != nil {
+		return err
+	}
+	columns, err := r.gh.ListProjectColumns(projID)
+	if err != nil {
+		return err
+	}
+	colID, ok := r.gh.GetCardColumnIDByName(columns, rule.Column)
+	if !ok {
+		return fmt.Errorf("column %q not found in project %q", rule.Column, rule.Project)
+	}
+	allowed, err := r.wipAllows(rule.Project, colID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("column %q: %w", rule.Column, ErrWIPLimit)
+	}
+	if card == nil {
+		return r.gh.CreateProjectCard(rule.Content, contentID, colID)
+	}
+	if err := r.gh.MoveProjectCard(*card.ID, colID); err != nil {
+		return fmt.Errorf("moving card %d: %w", *card.ID, err)
+	}
+	return nil
 }
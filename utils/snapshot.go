@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+)
+
+// DoneColumns defines which columns of a project count as done
+type DoneColumns struct {
+	Project string
+	Columns []string
+}
+
+// ColumnSnapshot shows the cards of a column and how long they have sat there
+type ColumnSnapshot struct {
+	Column         string  `json:"Column"`
+	Done           bool    `json:"Done"`
+	Count          int     `json:"Count"`
+	OldestAgeHours float64 `json:"OldestAgeHours"`
+	MedianAgeHours float64 `json:"MedianAgeHours"`
+	Cards          []*Card `json:"Cards"`
+}
+
+// DoneColumns returns the configured done columns per project
+func (r *RulesProcessor) DoneColumns() map[string][]string {
+	var done []DoneColumns
+	if err := r.decodeRules("DoneColumns", &done); err != nil {
+		r.log.WithError(err).Error("Error Decoding Done Columns")
+	}
+	columns := map[string][]string{}
+	for _, d := range done {
+		columns[d.Project] = d.Columns
+	}
+	return columns
+}
+
+// doneColumns returns the columns counting as done for a project
+func (r *Reporter) doneColumns(project string) []string {
+	if columns, ok := r.DoneColumns[project]; ok {
+		return columns
+	}
+	return r.DefaultDoneColumns
+}
+
+func (r *Reporter) isDone(project string, column string) bool {
+	for _, c := range r.doneColumns(project) {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSnapshot reports the cards of every column of a project
+func (r *Reporter) GetSnapshot(project *github.Project, now time.Time) ([]*ColumnSnapshot, error) {
+	columns, err := r.GH.ListProjectColumns(*project.ID)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot []*ColumnSnapshot
+	for _, col := range columns {
+		s := &ColumnSnapshot{Column: col.GetName(), Done: r.isDone(project.GetName(), col.GetName()), Cards: []*Card{}}
+		cards, err := r.GH.ListProjectCards(col.GetID())
+		if err != nil {
+			return nil, err
+		}
+		var ages []time.Duration
+		for _, c := range cards {
+			card := Card{ProjectCard: *c}
+			if c.ContentURL != nil {
+				if repo, contentType, number, err := r.StripContentURL(*c.ContentURL); err == nil {
+					card.Repo, card.Number = *repo, *number
+					if issue := r.lookupIssue(&card); issue != nil {
+						describeCard(&card, *contentType, issue)
+					}
+				}
+			}
+			if c.UpdatedAt != nil {
+				ages = append(ages, now.Sub(c.UpdatedAt.Time))
+			}
+			s.Cards = append(s.Cards, &card)
+		}
+		s.Count = len(s.Cards)
+		p := NewPercentiles(ages)
+		s.MedianAgeHours, s.OldestAgeHours = p.P50, p.Max
+		snapshot = append(snapshot, s)
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,476 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	deliveriesBucket = []byte("deliveries")
+	cardsBucket      = []byte("cards")
+	auditBucket      = []byte("audit")
+	snapshotsBucket  = []byte("snapshots")
+	// snapshotIndexBucket keeps summaries so snapshots can be listed cheaply
+	snapshotIndexBucket = []byte("snapshot_index")
+	deadLettersBucket   = []byte("dead_letters")
+	archiveRunsBucket   = []byte("archive_runs")
+	nudgesBucket        = []byte("nudges")
+)
+
+// Audit entry kinds
+const (
+	AuditDelivery = "delivery"
+	AuditCard     = "card"
+	AuditIssue    = "issue"
+)
+
+// Store persists webhook deliveries, card history and issue state transitions
+type Store struct {
+	db *bolt.DB
+}
+
+// Delivery records a webhook delivery
+type Delivery struct {
+	ID         string    `json:"ID"`
+	Event      string    `json:"Event"`
+	Action     string    `json:"Action,omitempty"`
+	Repo       string    `json:"Repo,omitempty"`
+	ReceivedAt time.Time `json:"ReceivedAt"`
+}
+
+// IssueTransition records an Issue or PR changing state
+type IssueTransition struct {
+	Repo    string    `json:"Repo"`
+	Number  int       `json:"Number"`
+	Content string    `json:"Content"`
+	Action  string    `json:"Action"`
+	State   string    `json:"State"`
+	At      time.Time `json:"At"`
+}
+
+// DeadLetter is a webhook delivery that failed every processing attempt
+type DeadLetter struct {
+	DeliveryID string          `json:"DeliveryID"`
+	Event      string          `json:"Event"`
+	Payload    json.RawMessage `json:"Payload"`
+	Error      string          `json:"Error"`
+	Attempts   int             `json:"Attempts"`
+	FailedAt   time.Time       `json:"FailedAt"`
+	// Done are the steps that succeeded before the delivery failed
+	Done []string `json:"Done,omitempty"`
+}
+
+// AuditEntry is a single record of the audit log
+type AuditEntry struct {
+	Seq  uint64          `json:"Seq"`
+	Kind string          `json:"Kind"`
+	At   time.Time       `json:"At"`
+	Data json.RawMessage `json:"Data"`
+}
+
+// NewDelivery describes a webhook delivery of an event
+func NewDelivery(id string, eventType string, event interface{}) Delivery {
+	d := Delivery{ID: id, Event: eventType, ReceivedAt: time.Now()}
+	if e, ok := event.(interface{ GetAction() string }); ok {
+		d.Action = e.GetAction()
+	}
+	if e, ok := event.(interface{ GetRepo() *github.Repository }); ok {
+		d.Repo = e.GetRepo().GetName()
+	}
+	return d
+}
+
+// NewIssueTransition describes the state change of an Issue or PR event,
+// returning false for events that don't change state
+func NewIssueTransition(event interface{}) (IssueTransition, bool) {
+	actions := map[string]bool{
+		"opened": true, "closed": true, "reopened": true, "transferred": true,
+		"deleted": true, "locked": true, "unlocked": true,
+	}
+	var t IssueTransition
+	switch e := event.(type) {
+	case *github.IssuesEvent:
+		t = IssueTransition{
+			Repo:    e.GetRepo().GetName(),
+			Number:  e.GetIssue().GetNumber(),
+			Content: "Issue",
+			Action:  e.GetAction(),
+			State:   e.GetIssue().GetState(),
+		}
+	case *github.PullRequestEvent:
+		t = IssueTransition{
+			Repo:    e.GetRepo().GetName(),
+			Number:  e.GetPullRequest().GetNumber(),
+			Content: "PullRequest",
+			Action:  e.GetAction(),
+			State:   e.GetPullRequest().GetState(),
+		}
+	default:
+		return t, false
+	}
+	t.At = time.Now()
+	return t, actions[t.Action]
+}
+
+// NewStore opens or creates the store at path
+func NewStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{deliveriesBucket, cardsBucket, auditBucket, snapshotsBucket, snapshotIndexBucket, deadLettersBucket, archiveRunsBucket, nudgesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the store
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// RecordDelivery stores a webhook delivery, returning false when it was
+// already recorded
+func (s *Store) RecordDelivery(d Delivery) (bool, error) {
+	recorded := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deliveriesBucket)
+		if b.Get([]byte(d.ID)) != nil {
+			return nil
+		}
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(d.ID), data); err != nil {
+			return err
+		}
+		recorded = true
+		return audit(tx, AuditDelivery, d.ReceivedAt, data)
+	})
+	return recorded, err
+}
+
+// DeleteDelivery forgets a webhook delivery, so a redelivery of it isn't
+// taken for a duplicate
+func (s *Store) DeleteDelivery(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).Delete([]byte(id))
+	})
+}
+
+// RecordCardEvent stores a card event
+func (s *Store) RecordCardEvent(e CardEvent) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket(cardsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := []byte(fmt.Sprintf("%020d/%020d/%020d", e.CardID, e.At.UnixNano(), seq))
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+		return audit(tx, AuditCard, e.At, data)
+	})
+}
+
+// CardEvents returns the stored events of a card, oldest first
+func (s *Store) CardEvents(cardID int64) ([]CardEvent, error) {
+	var events []CardEvent
+	prefix := []byte(fmt.Sprintf("%020d/", cardID))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(cardsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var e CardEvent
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// RecordIssueTransition stores an Issue or PR state change
+func (s *Store) RecordIssueTransition(t IssueTransition) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		return audit(tx, AuditIssue, t.At, data)
+	})
+}
+
+// Audit returns up to limit audit entries of a kind, newest first. An empty
+// kind returns every kind.
+func (s *Store) Audit(kind string, limit int) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(entries) < limit; k, v = c.Prev() {
+			var e AuditEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if kind == "" || e.Kind == kind {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// SaveSnapshot stores generated reports as a dated snapshot
+func (s *Store) SaveSnapshot(reports []Report, at time.Time) (*SnapshotSummary, error) {
+	var summary *SnapshotSummary
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		snapshot := ReportSnapshot{ID: strconv.FormatUint(seq, 10), CreatedAt: at, Reports: reports}
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, data); err != nil {
+			return err
+		}
+		summary = snapshot.Summary()
+		index, err := json.Marshal(summary)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(snapshotIndexBucket).Put(key, index)
+	})
+	return summary, err
+}
+
+// PruneSnapshots deletes all but the newest keep snapshots
+func (s *Store) PruneSnapshots(keep int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, index := tx.Bucket(snapshotsBucket), tx.Bucket(snapshotIndexBucket)
+		var old [][]byte
+		c := b.Cursor()
+		n := 0
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			if n++; n > keep {
+				old = append(old, append([]byte{}, k...))
+			}
+		}
+		for _, k := range old {
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Snapshots lists up to limit snapshot summaries, newest first
+func (s *Store) Snapshots(limit int) ([]*SnapshotSummary, error) {
+	summaries := []*SnapshotSummary{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(snapshotIndexBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(summaries) < limit; k, v = c.Prev() {
+			var summary SnapshotSummary
+			if err := json.Unmarshal(v, &summary); err != nil {
+				return err
+			}
+			summaries = append(summaries, &summary)
+		}
+		return nil
+	})
+	return summaries, err
+}
+
+// Snapshot gets a snapshot by ID, "latest" and "previous" pick the newest
+// two. It returns nil when the snapshot doesn't exist.
+func (s *Store) Snapshot(id string) (*ReportSnapshot, error) {
+	var snapshot *ReportSnapshot
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(snapshotsBucket)
+		var data []byte
+		switch id {
+		case "latest":
+			_, data = b.Cursor().Last()
+		case "previous":
+			c := b.Cursor()
+			if k, _ := c.Last(); k != nil {
+				_, data = c.Prev()
+			}
+		default:
+			seq, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				return nil
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			data = b.Get(key)
+		}
+		if data == nil {
+			return nil
+		}
+		snapshot = &ReportSnapshot{}
+		return json.Unmarshal(data, snapshot)
+	})
+	return snapshot, err
+}
+
+// SaveDeadLetter stores a failed delivery, replacing an earlier failure of it
+func (s *Store) SaveDeadLetter(d DeadLetter) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(deadLettersBucket).Put([]byte(d.DeliveryID), data)
+	})
+}
+
+// DeadLetters lists the failed deliveries, most recent failure first
+func (s *Store) DeadLetters() ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).ForEach(func(k, v []byte) error {
+			var d DeadLetter
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			letters = append(letters, d)
+			return nil
+		})
+	})
+	sort.SliceStable(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+	return letters, err
+}
+
+// DeadLetter gets a failed delivery, returning nil when it doesn't exist
+func (s *Store) DeadLetter(deliveryID string) (*DeadLetter, error) {
+	var d *DeadLetter
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(deadLettersBucket).Get([]byte(deliveryID))
+		if data == nil {
+			return nil
+		}
+		d = &DeadLetter{}
+		return json.Unmarshal(data, d)
+	})
+	return d, err
+}
+
+// DeleteDeadLetter removes a failed delivery
+func (s *Store) DeleteDeadLetter(deliveryID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLettersBucket).Delete([]byte(deliveryID))
+	})
+}
+
+// SaveArchiveRun stores the run of an archive rule
+func (s *Store) SaveArchiveRun(run ArchiveRun) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		b := tx.Bucket(archiveRunsBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, data)
+	})
+}
+
+// ArchiveRuns returns the stored archive rule runs, oldest first
+func (s *Store) ArchiveRuns() ([]ArchiveRun, error) {
+	runs := []ArchiveRun{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(archiveRunsBucket).ForEach(func(k, v []byte) error {
+			var run ArchiveRun
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	return runs, err
+}
+
+// RecordNudge stores when a stale rule acted on a card
+func (s *Store) RecordNudge(rule string, cardID int64, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data, err := at.MarshalText()
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(nudgesBucket).Put(nudgeKey(rule, cardID), data)
+	})
+}
+
+// Nudged returns when a stale rule last acted on a card, the zero time when
+// it never did
+func (s *Store) Nudged(rule string, cardID int64) (time.Time, error) {
+	var at time.Time
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(nudgesBucket).Get(nudgeKey(rule, cardID))
+		if data == nil {
+			return nil
+		}
+		return at.UnmarshalText(data)
+	})
+	return at, err
+}
+
+func nudgeKey(rule string, cardID int64) []byte {
+	return []byte(fmt.Sprintf("%020d/%s", cardID, rule))
+}
+
+func audit(tx *bolt.Tx, kind string, at time.Time, data []byte) error {
+	b := tx.Bucket(auditBucket)
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(AuditEntry{Seq: seq, Kind: kind, At: at, Data: data})
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return b.Put(key, entry)
+}
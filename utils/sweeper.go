@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
+)
+
+// StaleRule defines how long cards may sit in a column and what to do with
+// them afterwards
+type StaleRule struct {
+	Name    string
+	Project string
+	Column  string
+	Days    int
+	// Label is added to stale items, items already carrying it are skipped
+	Label string
+	// Comment is posted on stale items mentioning their assignees
+	Comment string
+	// MoveTo is the column stale cards are moved to
+	MoveTo string
+	DryRun bool
+}
+
+// Validate checks a stale rule names its project and column and waits at
+// least a day, as a missing days would act on every card
+func (s StaleRule) Validate() error {
+	switch {
+	case s.Project == "" || s.Column == "":
+		return errors.New("project and column are required")
+	case s.Days < 1:
+		return fmt.Errorf("days must be at least 1, not %d", s.Days)
+	}
+	return nil
+}
+
+// StaleRules returns the configured stale rules, leaving out invalid ones
+func (r *RulesProcessor) StaleRules() []StaleRule {
+	var rules []StaleRule
+	if err := r.decodeRules("StaleRules", &rules); err != nil {
+		r.log.WithError(err).Error("Error Decoding Stale Rules")
+		return nil
+	}
+	valid := rules[:0]
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			r.log.WithError(err).WithField("rule", rule.Name).Error("Ignoring Invalid Stale Rule")
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid
+}
+
+// SweepStaleCards applies the stale rules to every card sitting in a rule's
+// column for longer than its SLA
+func (r *RulesProcessor) SweepStaleCards() {
+	now := time.Now()
+	for _, rule := range r.StaleRules() {
+		l := r.log.WithField("rule", rule.Name)
+		projID, err := r.gh.GetProjectID(rule.Project)
+		if err != nil {
+			l.WithError(err).Warn("Unable to find project for stale rule")
+			continue
+		}
+		columns, err := r.gh.ListProjectColumns(projID)
+		if err != nil {
+			l.WithError(err).Error("Unable to list columns for stale rule")
+			continue
+		}
+		colID, ok := r.gh.GetCardColumnIDByName(columns, rule.Column)
+		if !ok {
+			l.WithField("column", rule.Column).Warn("Unable to get Column ID")
+			continue
+		}
+		cards, err := r.gh.ListProjectCards(colID)
+		if err != nil {
+			l.WithError(err).Error("Unable to list cards for stale rule")
+			continue
+		}
+		for _, card := range cards {
+			if card.ContentURL == nil || !CardIsStale(card, rule.Days, now) {
+				continue
+			}
+			matchedRule("stale", rule.Name)
+			r.nudge(rule, card)
+		}
+	}
+}
+
+// nudge applies a stale rule's actions to a single card
+func (r *RulesProcessor) nudge(rule StaleRule, card *github.ProjectCard) {
+	repo, number, ok := ParseContentURL(*card.ContentURL)
+	if !ok {
+		r.log.WithField("url", *card.ContentURL).Warn("Unable to parse card content")
+		return
+	}
+	issue, _ := r.gh.GetIssue(repo, number)
+	if issue == nil {
+		return
+	}
+	if rule.Label != "" && hasLabel(issue, rule.Label) {
+		return
+	}
+	l := r.log.WithFields(logrus.Fields{"rule": rule.Name, "repo": repo, "number": number})
+	if r.nudgedSinceMoved(rule, card) {
+		l.Debug("Already nudged since the card last moved")
+		return
+	}
+	l.Info("Stale rule matched")
+	if rule.DryRun {
+		l.Info("Dry run, skipping actions")
+		return
+	}
+	r.recordNudge(rule, card, time.Now())
+	if rule.Label != "" {
+		if err := r.gh.AddLabels(repo, number, rule.Label); err != nil {
+			l.WithError(err).Error("Error Labeling Stale Item")
+		}
+	}
+	if rule.Comment != "" {
+		if err := r.gh.CreateComment(repo, number, StaleComment(rule.Comment, issue.Assignees)); err != nil {
+			l.WithError(err).Error("Error Commenting on Stale Item")
+		}
+	}
+	if rule.MoveTo != "" {
+		content := "Issue"
+		if issue.IsPullRequest() {
+			content = "PullRequest"
+		}
+		move := LabelRule{Name: rule.Name, Project: rule.Project, Column: rule.MoveTo, Content: content}
+		if err := r.placeCard(move, *issue.ID, card); err != nil {
+			l.WithError(err).Error("Error Moving Stale Item")
+		}
+	}
+}
+
+// nudgedSinceMoved checks if a stale rule already acted on a card that hasn't
+// moved since, so rules without a label don't comment on every sweep
+func (r *RulesProcessor) nudgedSinceMoved(rule StaleRule, card *github.ProjectCard) bool {
+	var at time.Time
+	if r.store != nil {
+		var err error
+		if at, err = r.store.Nudged(rule.Name, card.GetID()); err != nil {
+			r.log.WithError(err).WithField("rule", rule.Name).Error("Error Reading Nudge")
+		}
+	} else {
+		r.mu.Lock()
+		at = r.nudges[fmt.Sprintf("%d/%s", card.GetID(), rule.Name)]
+		r.mu.Unlock()
+	}
+	return !at.IsZero() && card.GetUpdatedAt().Before(at)
+}
+
+// recordNudge remembers a stale rule acting on a card
+func (r *RulesProcessor) recordNudge(rule StaleRule, card *github.ProjectCard, at time.Time) {
+	if r.store != nil {
+		if err := r.store.RecordNudge(rule.Name, card.GetID(), at); err != nil {
+			r.log.WithError(err).WithField("rule", rule.Name).Error("Error Storing Nudge")
+		}
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nudges == nil {
+		r.nudges = map[string]time.Time{}
+	}
+	r.nudges[fmt.Sprintf("%d/%s", card.GetID(), rule.Name)] = at
+}
+
+// StaleComment prefixes a comment with mentions of the assignees
+func StaleComment(body string, assignees []*github.User) string {
+	var mentions []string
+	for _, a := range assignees {
+		mentions = append(mentions, fmt.Sprintf("@%s", a.GetLogin()))
+	}
+	if len(mentions) == 0 {
+		return body
+	}
+	return strings.Join(mentions, " ") + " " + body
+}
+
+func hasLabel(issue *github.Issue, name string) bool {
+	for _, l := range issue.Labels {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
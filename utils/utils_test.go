@@ -1,45 +1,1565 @@
 package utils_test
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
 	github "github.com/google/go-github/v32/github"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/secberus-oss/projector/utils"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 var _ = Describe("Utils", func() {
-	Describe("List All Org Hooks", func() {
+	Describe("Hook Reconciliation", func() {
+		var gh *utils.GH
+		BeforeEach(func() {
+			viper.Set("hook_url", "http://www.test.com")
+			viper.Set("hook_secret", "new")
+			viper.Set("hook_previous_secret", "old")
+			if gh == nil {
+				gh = utils.NewGH()
+			}
+		})
+		AfterEach(func() {
+			viper.Set("hook_secret", "")
+			viper.Set("hook_previous_secret", "")
+		})
+		Context("A hook created without a secret or newer events", func() {
+			It("should drift", func() {
+				hook := &github.Hook{
+					Config: map[string]interface{}{"url": "http://www.test.com", "content_type": "form"},
+					Events: []string{"pull_request", "issues"},
+					Active: github.Bool(true),
+				}
+				Expect(gh.HookDrift(hook)).To(Equal([]string{"events", "content_type", "secret"}))
+			})
+		})
+		Context("A reconciled hook", func() {
+			It("should not drift", func() {
+				hook := &github.Hook{
+					Config: map[string]interface{}{"url": "http://www.test.com", "content_type": "json", "secret": "********"},
+					Events: []string{"issue_comment", "issues", "project_card", "pull_request"},
+					Active: github.Bool(true),
+				}
+				Expect(gh.HookDrift(hook)).To(BeEmpty())
+			})
+		})
+		Context("Repo hook mode", func() {
+			It("should not need org hook rights", func() {
+				viper.Set("hook_mode", "repo")
+				viper.Set("hook_repos", "api, web")
+				defer viper.Set("hook_mode", "")
+				Expect(utils.NewGH().RequiredScopes()).To(Equal([]string{"repo", "read:org"}))
+			})
+		})
+		Context("Reconciling against GitHub", func() {
+			var fake *fakeGitHub
+			hook := func(id int, url string, events ...string) map[string]interface{} {
+				if len(events) == 0 {
+					events = utils.HookEvents
+				}
+				return map[string]interface{}{
+					"id":     id,
+					"config": map[string]interface{}{"url": url, "content_type": "json", "secret": "********"},
+					"events": events,
+					"active": true,
+				}
+			}
+			serve := func(hooks ...map[string]interface{}) *utils.GH {
+				fake = newFakeGitHub(map[string]interface{}{
+					"GET /orgs/secberus/hooks":       hooks,
+					"POST /orgs/secberus/hooks":      hook(9, "http://www.test.com"),
+					"PATCH /orgs/secberus/hooks/1":   hook(1, "http://www.test.com"),
+					"DELETE /orgs/secberus/hooks/2":  nil,
+					"DELETE /orgs/secberus/hooks/3":  nil,
+					"GET /repos/secberus/api/hooks":  []map[string]interface{}{},
+					"POST /repos/secberus/api/hooks": hook(8, "http://www.test.com"),
+				})
+				return fake.GH()
+			}
+			mutations := func() []string {
+				var m []string
+				for _, r := range fake.Requests() {
+					if !strings.HasPrefix(r, "GET ") {
+						m = append(m, r)
+					}
+				}
+				return m
+			}
+			AfterEach(func() {
+				fake.Close()
+			})
+			It("should leave a reconciled hook alone", func() {
+				gh := serve(hook(1, "http://www.test.com"), hook(4, "http://other.test.com"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(BeEmpty())
+				Expect(mutations()).To(BeEmpty())
+			})
+			It("should remove duplicate and stale hooks", func() {
+				gh := serve(hook(1, "http://www.test.com"), hook(2, "http://www.test.com"), hook(3, "http://old.test.com"), hook(4, "http://other.test.com"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{StaleURLs: []string{"http://old.test.com"}})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(Equal([]utils.HookChange{
+					{HookID: 2, URL: "http://www.test.com", Action: "deleted"},
+					{HookID: 3, URL: "http://old.test.com", Action: "deleted"},
+				}))
+				Expect(mutations()).To(Equal([]string{"DELETE /orgs/secberus/hooks/2", "DELETE /orgs/secberus/hooks/3"}))
+			})
+			It("should only report changes in a dry run", func() {
+				gh := serve(hook(2, "http://old.test.com"), hook(1, "http://www.test.com", "issues"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{StaleURLs: []string{"http://old.test.com"}, DryRun: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(HaveLen(2))
+				Expect(changes[1].Action).To(Equal("updated"))
+				Expect(mutations()).To(BeEmpty())
+			})
+			It("should keep the hook when its URL is listed as stale", func() {
+				gh := serve(hook(1, "http://www.test.com"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{StaleURLs: []string{"http://www.test.com"}})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(BeEmpty())
+				Expect(mutations()).To(BeEmpty())
+			})
+			It("should create a missing hook with the secret", func() {
+				gh := serve()
+				changes, err := gh.ReconcileHooks(utils.HookOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(Equal([]utils.HookChange{{HookID: 9, URL: "http://www.test.com", Action: "created"}}))
+				Expect(fake.Body("POST /orgs/secberus/hooks")).To(ContainSubstring(`"secret":"new"`))
+			})
+			It("should keep sending the secret when fixing a drifted hook", func() {
+				gh := serve(hook(1, "http://www.test.com", "issues"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(Equal([]utils.HookChange{{HookID: 1, URL: "http://www.test.com", Action: "updated", Changes: []string{"events"}}}))
+				Expect(mutations()).To(Equal([]string{"PATCH /orgs/secberus/hooks/1"}))
+				// GitHub drops the secret of a hook edited without one
+				Expect(fake.Body("PATCH /orgs/secberus/hooks/1")).To(ContainSubstring(`"secret":"new"`))
+			})
+			It("should replace the secret when rotating", func() {
+				gh := serve(hook(1, "http://www.test.com"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{RotateSecret: true})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes[0].Changes).To(Equal([]string{"secret"}))
+				Expect(fake.Body("PATCH /orgs/secberus/hooks/1")).To(ContainSubstring(`"secret":"new"`))
+			})
+			It("should create repo hooks in repo hook mode", func() {
+				viper.Set("hook_mode", "repo")
+				viper.Set("hook_repos", "api")
+				defer viper.Set("hook_mode", "")
+				gh := serve()
+				changes, err := gh.ReconcileHooks(utils.HookOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(changes).To(Equal([]utils.HookChange{{Repo: "api", HookID: 8, URL: "http://www.test.com", Action: "created"}}))
+				Expect(mutations()).To(Equal([]string{"POST /repos/secberus/api/hooks"}))
+			})
+			It("should report an org hook left over in repo hook mode", func() {
+				viper.Set("hook_mode", "repo")
+				viper.Set("hook_repos", "api")
+				defer viper.Set("hook_mode", "")
+				gh := serve(hook(5, "http://www.test.com"))
+				changes, err := gh.ReconcileHooks(utils.HookOptions{})
+				Expect(err).To(MatchError(ContainSubstring("org hook 5")))
+				Expect(changes).To(HaveLen(1))
+				Expect(gh.CheckHook()).To(MatchError(ContainSubstring("org hook 5")))
+			})
+		})
+		Context("Hook modes", func() {
+			AfterEach(func() {
+				viper.Set("hook_mode", "")
+				viper.Set("hook_repos", "")
+			})
+			It("should accept org and repo mode", func() {
+				Expect(utils.CheckHookMode()).To(Succeed())
+				viper.Set("hook_mode", "repo")
+				viper.Set("hook_repos", "api")
+				Expect(utils.CheckHookMode()).To(Succeed())
+			})
+			It("should reject unknown modes", func() {
+				viper.Set("hook_mode", "repos")
+				Expect(utils.CheckHookMode()).To(MatchError(ContainSubstring(`not "repos"`)))
+			})
+			It("should reject repo mode without repos", func() {
+				viper.Set("hook_mode", "repo")
+				Expect(utils.CheckHookMode()).NotTo(Succeed())
+			})
+		})
+		Context("A delivery signed with the previous secret", func() {
+			It("should be accepted during a rotation", func() {
+				body := `{"action":"opened"}`
+				mac := hmac.New(sha1.New, []byte("old"))
+				mac.Write([]byte(body))
+				req, _ := http.NewRequest("POST", "/webhook", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+				Expect(gh.ValidatePayload(req)).To(Equal([]byte(body)))
+			})
+		})
+	})
+	Describe("Stale Cards", func() {
+		var (
+			now  = time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+			card *github.ProjectCard
+		)
+
+		BeforeEach(func() {
+			card = &github.ProjectCard{UpdatedAt: &github.Timestamp{Time: now.AddDate(0, 0, -10)}}
+		})
+		Context("A card untouched for longer than the rule allows", func() {
+			It("should be stale", func() {
+				Expect(utils.CardIsStale(card, 7, now)).To(Equal(true))
+			})
+		})
+		Context("A card updated recently", func() {
+			It("should not be stale", func() {
+				Expect(utils.CardIsStale(card, 14, now)).To(Equal(false))
+			})
+		})
+	})
+	Describe("Archive and Stale Rules", func() {
+		var (
+			fake  *fakeGitHub
+			rules *utils.RulesProcessor
+		)
+		config := `
+ArchiveRules:
+- name: Done
+  project: Kanban
+  columns: [Done]
+  days: 30
+- name: No Days
+  project: Kanban
+  columns: [Done]
+- name: No Columns
+  project: Kanban
+  days: 30
+StaleRules:
+- name: Review
+  project: Kanban
+  column: Review
+  days: 7
+  label: stale
+- name: No Project
+  column: Review
+  days: 7
+- name: Zero Days
+  project: Kanban
+  column: Review
+  days: 0
+`
+		BeforeEach(func() {
+			fake = newFakeGitHub(nil)
+			rules = fake.RulesProcessor(fake.GH(), config)
+		})
+		AfterEach(func() {
+			fake.Close()
+		})
+		Context("Archive rules missing columns or days", func() {
+			It("should be ignored", func() {
+				Expect(utils.ArchiveRule{Project: "Kanban", Columns: []string{"Done"}}.Validate()).NotTo(Succeed())
+				Expect(rules.ArchiveRules()).To(HaveLen(1))
+				Expect(rules.ArchiveRules()[0].Name).To(Equal("Done"))
+			})
+		})
+		Context("Stale rules missing a project or days", func() {
+			It("should be ignored", func() {
+				Expect(utils.StaleRule{Project: "Kanban", Column: "Review", Days: -1}.Validate()).NotTo(Succeed())
+				Expect(rules.StaleRules()).To(HaveLen(1))
+				Expect(rules.StaleRules()[0].Name).To(Equal("Review"))
+			})
+		})
+	})
+	Describe("Stale Comments", func() {
+		Context("An item with assignees", func() {
+			It("should mention every assignee", func() {
+				alice, bob := "alice", "bob"
+				assignees := []*github.User{{Login: &alice}, {Login: &bob}}
+				Expect(utils.StaleComment("ping", assignees)).To(Equal("@alice @bob ping"))
+			})
+		})
+		Context("An item without assignees", func() {
+			It("should leave the comment untouched", func() {
+				Expect(utils.StaleComment("ping", nil)).To(Equal("ping"))
+			})
+		})
+	})
+	Describe("Parse Commands", func() {
+		Context("A comment with a quoted argument", func() {
+			It("should keep the argument together", func() {
+				cmd, ok := utils.ParseCommand("Looks good\n/projector move \"In Review\" Bugs")
+				Expect(ok).To(Equal(true))
+				Expect(cmd.Name).To(Equal("move"))
+				Expect(cmd.Args).To(Equal([]string{"In Review", "Bugs"}))
+			})
+		})
+		Context("A comment without a command", func() {
+			It("should not be parsed", func() {
+				_, ok := utils.ParseCommand("please /projector move this")
+				Expect(ok).To(Equal(false))
+			})
+		})
+	})
+	Describe("Commands", func() {
+		var (
+			fake  *fakeGitHub
+			rules *utils.RulesProcessor
+		)
+		comment := func(user string, body string) *github.IssueCommentEvent {
+			return &github.IssueCommentEvent{
+				Action:  github.String("created"),
+				Comment: &github.IssueComment{ID: github.Int64(5), Body: github.String(body), User: &github.User{Login: github.String(user)}},
+				Issue:   &github.Issue{ID: github.Int64(8), Number: github.Int(3)},
+				Repo:    &github.Repository{Name: github.String("api")},
+			}
+		}
+		BeforeEach(func() {
+			fake = newFakeGitHub(map[string]interface{}{
+				"GET /orgs/secberus/projects":                             []map[string]interface{}{{"id": 1, "name": "Kanban"}},
+				"GET /repos/secberus/api/collaborators/reader/permission": map[string]interface{}{"permission": "read"},
+				"GET /repos/secberus/api/collaborators/writer/permission": map[string]interface{}{"permission": "write"},
+				"GET /projects/1/columns":                                 []map[string]interface{}{},
+				"POST /repos/secberus/api/issues/3/comments":              map[string]interface{}{"id": 6},
+				"POST /repos/secberus/api/issues/comments/5/reactions":    map[string]interface{}{"id": 7},
+			})
+			rules = fake.RulesProcessor(fake.GH(), "")
+		})
+		AfterEach(func() {
+			fake.Close()
+		})
+		Context("A command from a read-only commenter", func() {
+			It("should be refused", func() {
+				Expect(rules.ProcessIssueCommentEvent(comment("reader", "/projector remove Kanban"))).To(Succeed())
+				Expect(fake.Requests()).NotTo(ContainElement("GET /projects/1/columns"))
+				Expect(fake.Requests()).NotTo(ContainElement(HavePrefix("DELETE")))
+				Expect(fake.Body("POST /repos/secberus/api/issues/3/comments")).To(ContainSubstring("reader needs write access to api"))
+				Expect(fake.Body("POST /repos/secberus/api/issues/comments/5/reactions")).To(ContainSubstring("confused"))
+			})
+		})
+		Context("A command that fails", func() {
+			It("should answer with a comment instead of an error", func() {
+				Expect(rules.ProcessIssueCommentEvent(comment("writer", "/projector project Kanban"))).To(Succeed())
+				Expect(fake.Requests()).To(ContainElement("GET /projects/1/columns"))
+				Expect(fake.Body("POST /repos/secberus/api/issues/3/comments")).To(ContainSubstring("@writer `/projector project` failed:"))
+				Expect(fake.Body("POST /repos/secberus/api/issues/3/comments")).To(ContainSubstring("has no columns"))
+				Expect(fake.Body("POST /repos/secberus/api/issues/comments/5/reactions")).To(ContainSubstring("confused"))
+			})
+		})
+		Context("A command whose answer cannot be posted", func() {
+			It("should return an error", func() {
+				fake.mu.Lock()
+				delete(fake.routes, "POST /repos/secberus/api/issues/3/comments")
+				fake.mu.Unlock()
+				Expect(rules.ProcessIssueCommentEvent(comment("writer", "/projector frobnicate"))).NotTo(Succeed())
+			})
+		})
+		Context("A request of the rules that fails for good", func() {
+			It("should be listed with the failed requests of the shared client", func() {
+				fake.mu.Lock()
+				fake.routes["POST /repos/secberus/api/issues/3/comments"] = 500
+				fake.mu.Unlock()
+				gh := fake.GH()
+				rules := utils.NewRulesProcessor(gh)
+				Expect(rules.ProcessIssueCommentEvent(comment("writer", "/projector frobnicate"))).NotTo(Succeed())
+				Expect(gh.FailedRequests.List()).To(HaveLen(1))
+				Expect(gh.FailedRequests.List()[0].Status).To(Equal(500))
+			})
+		})
+	})
+	Describe("Lifecycle Rules", func() {
+		Context("A merged rule", func() {
+			rule := utils.LifecycleRule{Action: "merged", Content: "PullRequest"}
+			It("should match closed PRs that were merged", func() {
+				Expect(rule.Matches("closed", "PullRequest", true)).To(Equal(true))
+			})
+			It("should not match closed PRs that were not merged", func() {
+				Expect(rule.Matches("closed", "PullRequest", false)).To(Equal(false))
+			})
+		})
+		Context("A rule without content", func() {
+			rule := utils.LifecycleRule{Action: "closed"}
+			It("should match Issues and PRs", func() {
+				Expect(rule.Matches("closed", "Issue", false)).To(Equal(true))
+				Expect(rule.Matches("closed", "PullRequest", false)).To(Equal(true))
+			})
+		})
+		Context("Cards of changed Issues", func() {
+			var (
+				fake  *fakeGitHub
+				rules *utils.RulesProcessor
+			)
+			config := `
+LifecycleRules:
+- name: Transferred
+  action: transferred
+  project: Kanban
+- name: Deleted
+  action: deleted
+  project: Kanban
+  remove: true
+`
+			event := func(action string) *github.IssuesEvent {
+				return &github.IssuesEvent{
+					Action: github.String(action),
+					Issue:  &github.Issue{ID: github.Int64(8), Number: github.Int(3)},
+					Repo:   &github.Repository{Name: github.String("api")},
+				}
+			}
+			BeforeEach(func() {
+				fake = newFakeGitHub(map[string]interface{}{
+					"GET /orgs/secberus/projects":        []map[string]interface{}{{"id": 1, "name": "Kanban"}},
+					"GET /projects/1/columns":            []map[string]interface{}{{"id": 10, "name": "To Do"}, {"id": 11, "name": "In Progress"}},
+					"GET /projects/columns/10/cards":     []map[string]interface{}{},
+					"POST /projects/columns/11/cards":    map[string]interface{}{"id": 102},
+					"DELETE /projects/columns/cards/101": nil,
+				})
+				fake.routes["GET /projects/columns/11/cards"] = []map[string]interface{}{{
+					"id":          101,
+					"content_url": fake.URL + "/repos/secberus/api/issues/3",
+					"column_url":  fake.URL + "/projects/columns/11",
+				}}
+				rules = fake.RulesProcessor(fake.GH(), config)
+			})
+			AfterEach(func() {
+				fake.Close()
+			})
+			It("should re-point the card of a transferred Issue in the same column", func() {
+				payload := []byte(`{"action":"transferred","changes":{"new_issue":{"id":99,"number":1}}}`)
+				Expect(rules.ProcessLifecycleRules(event("transferred"), payload)).To(Succeed())
+				Expect(fake.Body("POST /projects/columns/11/cards")).To(ContainSubstring(`"content_id":99`))
+				Expect(fake.Body("POST /projects/columns/11/cards")).To(ContainSubstring(`"content_type":"Issue"`))
+				Expect(fake.Requests()).To(ContainElement("DELETE /projects/columns/cards/101"))
+			})
+			It("should remove the card of a deleted Issue", func() {
+				Expect(rules.ProcessLifecycleRules(event("deleted"), nil)).To(Succeed())
+				Expect(fake.Requests()).To(ContainElement("DELETE /projects/columns/cards/101"))
+				Expect(fake.Requests()).NotTo(ContainElement(HavePrefix("POST")))
+			})
+		})
+	})
+	Describe("Report Windows", func() {
+		now := time.Date(2020, 8, 15, 0, 0, 0, 0, time.UTC)
+		Context("A relative window", func() {
+			It("should start that long before now", func() {
+				w, err := utils.ParseReportWindow("", "", "14d", now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(w.Since).To(Equal(now.AddDate(0, 0, -14)))
+				Expect(w.Until.IsZero()).To(Equal(true))
+			})
+		})
+		Context("An absolute window", func() {
+			It("should contain only times between since and until", func() {
+				w, err := utils.ParseReportWindow("2020-08-01", "2020-08-10", "", now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(w.Contains(time.Date(2020, 8, 5, 0, 0, 0, 0, time.UTC))).To(Equal(true))
+				Expect(w.Contains(time.Date(2020, 8, 11, 0, 0, 0, 0, time.UTC))).To(Equal(false))
+			})
+		})
+		Context("An until date", func() {
+			It("should include the whole day", func() {
+				w, err := utils.ParseReportWindow("2020-08-01", "2020-08-10", "", now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(w.Contains(time.Date(2020, 8, 10, 18, 0, 0, 0, time.UTC))).To(Equal(true))
+				Expect(w.Contains(time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC))).To(Equal(true))
+			})
+		})
+		Context("An inverted window", func() {
+			It("should be rejected", func() {
+				_, err := utils.ParseReportWindow("2020-08-10", "2020-08-01", "", now)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("A velocity period", func() {
+			It("should be at least an hour", func() {
+				Expect(utils.CheckVelocityPeriod(utils.ReportWindow{}, time.Second, now)).NotTo(Succeed())
+				Expect(utils.CheckVelocityPeriod(utils.ReportWindow{}, time.Hour, now)).To(Succeed())
+			})
+			It("should not split the window into too many periods", func() {
+				w, err := utils.ParseReportWindow("2000-01-01", "", "", now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(utils.CheckVelocityPeriod(w, 24*time.Hour, now)).NotTo(Succeed())
+				Expect(utils.CheckVelocityPeriod(w, 365*24*time.Hour, now)).To(Succeed())
+			})
+		})
+	})
+	Describe("Card Flow", func() {
 		var (
-			hook    *github.Hook
-			hooks   []*github.Hook
-			hookURL string = "http://www.test.com"
-			hookID  int64  = 1234567
+			day     = 24 * time.Hour
+			opened  = time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+			closed  = opened.Add(10 * day)
+			columns = map[int64]string{1: "To Do", 2: "In Progress", 3: "Done"}
+			events  = []utils.CardEvent{
+				{CardID: 9, Action: "created", ColumnID: 1, At: opened},
+				{CardID: 9, Action: "moved", ColumnID: 2, At: opened.Add(4 * day)},
+				{CardID: 9, Action: "moved", ColumnID: 3, At: closed},
+			}
+		)
+		Context("A card moved through the board", func() {
+			flow := utils.ComputeCardFlow(events, columns, &opened, &closed, "In Progress")
+			It("should measure lead time from opening to closing", func() {
+				Expect(flow.LeadTime).To(Equal(10 * day))
+			})
+			It("should measure cycle time from the start column", func() {
+				Expect(flow.CycleTime).To(Equal(6 * day))
+			})
+			It("should measure time in each column", func() {
+				Expect(flow.InColumn).To(Equal(map[string]time.Duration{"To Do": 4 * day, "In Progress": 6 * day}))
+			})
+		})
+		Context("Flows of labeled cards", func() {
+			It("should break time in column down by label", func() {
+				flow := utils.ComputeCardFlow(events, columns, &opened, &closed, "In Progress")
+				bug := &utils.Card{Labels: []*github.Label{{Name: github.String("bug")}}}
+				m := utils.SummarizeFlows([]*utils.Card{bug, {}}, []utils.CardFlow{flow, flow})
+				Expect(m.TimeInColumn["To Do"].Count).To(Equal(2))
+				Expect(m.Labels["bug"].TimeInColumn["In Progress"]).To(Equal(utils.NewPercentiles([]time.Duration{6 * day})))
+			})
+		})
+		Context("Percentiles", func() {
+			It("should use the nearest rank", func() {
+				p := utils.NewPercentiles([]time.Duration{4 * time.Hour, time.Hour, 2 * time.Hour, 3 * time.Hour})
+				Expect(p.Count).To(Equal(4))
+				Expect(p.P50).To(Equal(2.0))
+				Expect(p.P95).To(Equal(4.0))
+			})
+		})
+	})
+	Describe("Store", func() {
+		var (
+			store *utils.Store
+			dir   string
 		)
 
 		BeforeEach(func() {
-			hookConfig := map[string]interface{}{
-				"url":          hookURL,
-				"content_type": "json",
+			var err error
+			dir, err = ioutil.TempDir("", "projector")
+			Expect(err).NotTo(HaveOccurred())
+			store, err = utils.NewStore(filepath.Join(dir, "test.db"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+		AfterEach(func() {
+			store.Close()
+			os.RemoveAll(dir)
+		})
+		Context("A delivery received twice", func() {
+			It("should only be recorded once", func() {
+				d := utils.Delivery{ID: "abc", Event: "issues"}
+				Expect(store.RecordDelivery(d)).To(Equal(true))
+				Expect(store.RecordDelivery(d)).To(Equal(false))
+			})
+		})
+		Context("Card events stored out of order", func() {
+			It("should be returned oldest first", func() {
+				at := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+				Expect(store.RecordCardEvent(utils.CardEvent{CardID: 1, Action: "moved", At: at.Add(time.Hour)})).To(Succeed())
+				Expect(store.RecordCardEvent(utils.CardEvent{CardID: 1, Action: "created", At: at})).To(Succeed())
+				Expect(store.RecordCardEvent(utils.CardEvent{CardID: 2, Action: "created", At: at})).To(Succeed())
+				events, err := store.CardEvents(1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(events).To(HaveLen(2))
+				Expect(events[0].Action).To(Equal("created"))
+			})
+		})
+		Context("A nudged card", func() {
+			It("should remember when it was nudged", func() {
+				at := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+				Expect(store.Nudged("stale", 1)).To(BeZero())
+				Expect(store.RecordNudge("stale", 1, at)).To(Succeed())
+				Expect(store.Nudged("stale", 1)).To(Equal(at))
+				Expect(store.Nudged("other", 1)).To(BeZero())
+			})
+		})
+		Context("Archive runs", func() {
+			It("should be returned oldest first", func() {
+				Expect(store.SaveArchiveRun(utils.ArchiveRun{Rule: "first"})).To(Succeed())
+				Expect(store.SaveArchiveRun(utils.ArchiveRun{Rule: "second", CardIDs: []int64{1}})).To(Succeed())
+				runs, err := store.ArchiveRuns()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(runs).To(HaveLen(2))
+				Expect(runs[1].CardIDs).To(Equal([]int64{1}))
+			})
+		})
+		Context("A delivery failing again", func() {
+			It("should replace its dead letter", func() {
+				at := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+				Expect(store.SaveDeadLetter(utils.DeadLetter{DeliveryID: "a", Payload: []byte(`{}`), Attempts: 3, FailedAt: at})).To(Succeed())
+				Expect(store.SaveDeadLetter(utils.DeadLetter{DeliveryID: "b", Payload: []byte(`{}`), Attempts: 3, FailedAt: at.Add(time.Hour)})).To(Succeed())
+				Expect(store.SaveDeadLetter(utils.DeadLetter{DeliveryID: "a", Payload: []byte(`{}`), Attempts: 1, FailedAt: at.Add(2 * time.Hour)})).To(Succeed())
+				letters, err := store.DeadLetters()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(letters).To(HaveLen(2))
+				Expect(letters[0].DeliveryID).To(Equal("a"))
+				Expect(letters[0].Attempts).To(Equal(1))
+				Expect(store.DeleteDeadLetter("a")).To(Succeed())
+				Expect(store.DeadLetter("a")).To(BeNil())
+			})
+		})
+		Context("Snapshots past the retention", func() {
+			It("should be pruned oldest first", func() {
+				for i := 0; i < 3; i++ {
+					_, err := store.SaveSnapshot([]utils.Report{}, time.Now())
+					Expect(err).NotTo(HaveOccurred())
+				}
+				Expect(store.PruneSnapshots(2)).To(Succeed())
+				summaries, err := store.Snapshots(10)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(summaries).To(HaveLen(2))
+				Expect(summaries[1].ID).To(Equal("2"))
+				Expect(store.Snapshot("1")).To(BeNil())
+			})
+		})
+	})
+	Describe("Report Renderers", func() {
+		Context("A format parameter", func() {
+			It("should win over the Accept header", func() {
+				r, ok := utils.RendererFor("csv", "text/html")
+				Expect(ok).To(Equal(true))
+				Expect(r.ContentType()).To(Equal("text/csv"))
+			})
+		})
+		Context("An Accept header", func() {
+			It("should pick the first supported type", func() {
+				r, ok := utils.RendererFor("", "application/xml, text/markdown;q=0.9")
+				Expect(ok).To(Equal(true))
+				Expect(r.ContentType()).To(Equal("text/markdown"))
+			})
+			It("should reject unsupported types", func() {
+				_, ok := utils.RendererFor("", "application/xml")
+				Expect(ok).To(Equal(false))
+			})
+		})
+		Context("A markdown report", func() {
+			It("should render a label table", func() {
+				name := "type: bug"
+				var b strings.Builder
+				reports := []utils.Report{{ProjectBoard: "Kanban", IssuesClosed: 2, LabelCounts: []*utils.LabelCount{{Name: &name, Count: 2}}}}
+				Expect(utils.MarkdownRenderer{}.Render(&b, reports)).To(Succeed())
+				Expect(b.String()).To(ContainSubstring("| type: bug | 2 |"))
+			})
+		})
+	})
+	Describe("Label Groups", func() {
+		var (
+			groups = []utils.LabelGroup{{Name: "effort", Prefix: "effort:", Sum: true}, {Name: "type", Prefix: "type:"}}
+			start  = time.Date(2020, 8, 3, 0, 0, 0, 0, time.UTC)
+			card   = func(closed time.Time, labels ...string) *utils.Card {
+				c := &utils.Card{ClosedAt: &closed}
+				for i := range labels {
+					c.Labels = append(c.Labels, &github.Label{Name: &labels[i]})
+				}
+				return c
+			}
+			cards = []*utils.Card{
+				card(start.AddDate(0, 0, 1), "effort: 3", "type: bug"),
+				card(start.AddDate(0, 0, 2), "effort: 5", "type: feature"),
+				card(start.AddDate(0, 0, 8), "effort: 2", "type: bug"),
+			}
+		)
+		Context("Grouping labels by prefix", func() {
+			It("should bucket values and sum story points", func() {
+				counts := utils.GroupLabels(groups, cards)
+				Expect(counts[0].Points).To(Equal(10.0))
+				Expect(counts[1].Buckets).To(Equal(map[string]int{"bug": 2, "feature": 1}))
+			})
+		})
+		Context("Velocity over two weeks", func() {
+			It("should sum story points per week", func() {
+				v := utils.Velocity(groups, cards, start, start.AddDate(0, 0, 14), 7*24*time.Hour)
+				Expect(v).To(HaveLen(2))
+				Expect(v[0].ItemsClosed).To(Equal(2))
+				Expect(v[0].StoryPoints).To(Equal(8.0))
+				Expect(v[1].StoryPoints).To(Equal(2.0))
+			})
+		})
+		Context("Velocity without a window or closed cards", func() {
+			It("should be empty", func() {
+				r := &utils.Reporter{Period: 7 * 24 * time.Hour}
+				Expect(r.GetVelocity([]*utils.Card{{}})).To(BeNil())
+			})
+		})
+		Context("Velocity without a window and an old card", func() {
+			It("should cover the most recent periods only", func() {
+				r := &utils.Reporter{Period: 7 * 24 * time.Hour}
+				Expect(len(r.GetVelocity([]*utils.Card{card(time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC))}))).To(BeNumerically("<=", 105))
+			})
+		})
+	})
+	Describe("Report Sinks", func() {
+		Context("A file sink", func() {
+			It("should write the rendered reports", func() {
+				dir, err := ioutil.TempDir("", "projector")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+				sink := utils.ReportSink{Name: "csv", Type: utils.SinkFile, Path: filepath.Join(dir, "reports.csv"), Format: "csv"}
+				reports := []utils.Report{{ProjectBoard: "Kanban", IssuesClosed: 3}}
+				Expect(utils.NewPublisher(nil).Publish(sink, reports)).To(Succeed())
+				data, err := ioutil.ReadFile(sink.Path)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(ContainSubstring("Kanban,,,3,,"))
+			})
+		})
+		Context("An unknown sink", func() {
+			It("should fail", func() {
+				Expect(utils.NewPublisher(nil).Publish(utils.ReportSink{Type: "fax"}, nil)).NotTo(Succeed())
+			})
+		})
+	})
+	Describe("Rate Limits", func() {
+		now := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+		reset := strconv.FormatInt(now.Add(10*time.Minute).Unix(), 10)
+		rateLimited := func(status int, remaining string) *http.Response {
+			rsp := &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(""))}
+			rsp.Header.Set("X-RateLimit-Remaining", remaining)
+			rsp.Header.Set("X-RateLimit-Reset", reset)
+			return rsp
+		}
+		Context("A response with plenty of requests left", func() {
+			It("should not wait", func() {
+				Expect(utils.RateLimitWait(rateLimited(200, "4000"), now)).To(Equal(time.Duration(0)))
+			})
+		})
+		Context("A response with the limit almost used up", func() {
+			It("should wait for the reset", func() {
+				Expect(utils.RateLimitWait(rateLimited(200, "3"), now)).To(Equal(10 * time.Minute))
+			})
+		})
+		Context("A secondary rate limit", func() {
+			It("should wait for Retry-After", func() {
+				rsp := rateLimited(403, "4000")
+				rsp.Header.Set("Retry-After", "30")
+				Expect(utils.RateLimitWait(rsp, now)).To(Equal(30 * time.Second))
+			})
+		})
+	})
+	Describe("Retries", func() {
+		var (
+			statuses []int
+			calls    int
+			failed   *utils.FailedRequests
+			client   *http.Client
+		)
+		BeforeEach(func() {
+			calls, failed = 0, &utils.FailedRequests{}
+			next := roundTripper(func(req *http.Request) (*http.Response, error) {
+				status := statuses[calls]
+				calls++
+				rsp := &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}
+				if status == http.StatusTooManyRequests {
+					rsp.Header.Set("Retry-After", "0")
+				}
+				return rsp, nil
+			})
+			client = &http.Client{Transport: utils.NewRetryTransport(next, failed)}
+		})
+		Context("A read failing with a bad gateway", func() {
+			It("should be retried", func() {
+				statuses = []int{502, 200}
+				rsp, err := client.Get("https://api.github.com/orgs/acme/repos")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rsp.StatusCode).To(Equal(200))
+				Expect(calls).To(Equal(2))
+				Expect(failed.List()).To(BeEmpty())
+			})
+		})
+		Context("A mutation hitting a secondary rate limit", func() {
+			It("should be retried with its body", func() {
+				statuses = []int{429, 201}
+				rsp, err := client.Post("https://api.github.com/repos/acme/api/issues", "application/json", strings.NewReader(`{"title":"x"}`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rsp.StatusCode).To(Equal(201))
+				Expect(calls).To(Equal(2))
+			})
+		})
+		Context("An unsafe mutation failing with a bad gateway", func() {
+			It("should be dead-lettered without a retry", func() {
+				statuses = []int{502}
+				rsp, err := client.Post("https://api.github.com/repos/acme/api/issues/comments/7/reactions/x", "application/json", strings.NewReader("{}"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rsp.StatusCode).To(Equal(502))
+				Expect(calls).To(Equal(1))
+				Expect(failed.List()).To(HaveLen(1))
+				Expect(failed.List()[0].Endpoint).To(Equal("/repos/:owner/:repo/issues/comments/:id/reactions/x"))
+			})
+		})
+		Context("A rate limited request that keeps failing", func() {
+			It("should be dead-lettered after the retries", func() {
+				statuses = []int{429, 429, 429, 429}
+				rsp, err := client.Get("https://api.github.com/orgs/acme/projects")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rsp.StatusCode).To(Equal(429))
+				Expect(calls).To(Equal(4))
+				Expect(failed.List()[0].Attempts).To(Equal(4))
+			})
+		})
+	})
+	Describe("Report Breakdowns", func() {
+		Context("Unknown dimensions", func() {
+			It("should be rejected", func() {
+				_, err := utils.ParseDimensions("assignee,color")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+		Context("Cards grouped by assignee", func() {
+			It("should count closed items and WIP per assignee", func() {
+				bug := "type: bug"
+				closed := []*utils.Card{
+					{Assignees: []string{"alice", "bob"}, Labels: []*github.Label{{Name: &bug}}},
+					{Assignees: []string{"alice"}},
+				}
+				open := []*utils.Card{{}}
+				b := (&utils.Reporter{}).BreakdownCards([]string{utils.ByAssignee}, closed, open)
+				Expect(b).To(HaveLen(1))
+				Expect(b[0].Groups).To(HaveLen(3))
+				Expect(b[0].Groups[0].Key).To(Equal("alice"))
+				Expect(b[0].Groups[0].Closed).To(Equal(2))
+				Expect(b[0].Groups[0].LabelCounts[0].Count).To(Equal(1))
+				Expect(b[0].Groups[2].Key).To(Equal("unassigned"))
+				Expect(b[0].Groups[2].WIP).To(Equal(1))
+			})
+		})
+	})
+	Describe("Report Diffs", func() {
+		var (
+			bug, feature = "type: bug", "type: feature"
+			from         = utils.Report{
+				ProjectBoard: "Kanban",
+				IssuesClosed: 10,
+				LabelCounts:  []*utils.LabelCount{{Name: &bug, Count: 4}, {Name: &feature, Count: 6}},
+				ProjectCards: []*utils.Card{{Repo: "api", Number: 1}, {Repo: "api", Number: 2}},
+			}
+			to = utils.Report{
+				ProjectBoard: "Kanban",
+				IssuesClosed: 13,
+				LabelCounts:  []*utils.LabelCount{{Name: &bug, Count: 7}, {Name: &feature, Count: 6}},
+				ProjectCards: []*utils.Card{{Repo: "api", Number: 2}, {Repo: "web", Number: 3}},
+			}
+		)
+		Context("Two reports of a project", func() {
+			d := utils.DiffReports(from, to)
+			It("should list added and removed items", func() {
+				Expect(d.Columns[0].Added).To(Equal([]string{"web#3"}))
+				Expect(d.Columns[0].Removed).To(Equal([]string{"api#1"}))
+			})
+			It("should only keep changed labels", func() {
+				Expect(d.LabelDeltas).To(Equal(map[string]int{"type: bug": 3}))
+			})
+			It("should compute the throughput change", func() {
+				Expect(d.Throughput.Delta).To(Equal(3))
+				Expect(*d.Throughput.Percent).To(Equal(30.0))
+			})
+		})
+	})
+	Describe("Board Snapshots", func() {
+		var (
+			gh  *fakeGitHub
+			r   *utils.Reporter
+			now = time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+		)
+		card := func(id int, number int, age time.Duration) map[string]interface{} {
+			c := map[string]interface{}{"id": id, "updated_at": now.Add(-age).Format(time.RFC3339)}
+			if number > 0 {
+				c["content_url"] = gh.URL + "/repos/secberus/api/issues/" + strconv.Itoa(number)
+			}
+			return c
+		}
+		issue := func(number int, state string) map[string]interface{} {
+			return map[string]interface{}{"number": number, "state": state, "title": "Item " + strconv.Itoa(number)}
+		}
+		BeforeEach(func() {
+			gh = newFakeGitHub(map[string]interface{}{})
+			gh.routes = map[string]interface{}{
+				"GET /projects/1/columns": []map[string]interface{}{{"id": 10, "name": "To Do"}, {"id": 11, "name": "Shipped"}},
+				"GET /projects/columns/10/cards": []map[string]interface{}{
+					card(100, 1, 48*time.Hour), card(101, 2, 24*time.Hour), card(102, 0, 12*time.Hour),
+				},
+				"GET /projects/columns/11/cards":   []map[string]interface{}{card(103, 3, time.Hour)},
+				"GET /repos/secberus/api/issues/1": issue(1, "open"),
+				"GET /repos/secberus/api/issues/2": issue(2, "closed"),
+				"GET /repos/secberus/api/issues/3": issue(3, "open"),
+			}
+			r = utils.NewReporter(gh.GH())
+			r.DoneColumns = map[string][]string{"Kanban": {"Shipped"}}
+		})
+		AfterEach(func() {
+			gh.Close()
+		})
+		project := &github.Project{ID: github.Int64(1), Name: github.String("Kanban")}
+		Context("A project with its own done columns", func() {
+			It("should leave them out of the open cards", func() {
+				open, err := r.GetOpenCards(project)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(open).To(HaveLen(1))
+				Expect(open[0].Number).To(Equal(1))
+				Expect(open[0].Name).To(Equal("Item 1"))
+			})
+		})
+		Context("A full board snapshot", func() {
+			It("should flag the done columns", func() {
+				snapshot, err := r.GetSnapshot(project, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshot).To(HaveLen(2))
+				Expect(snapshot[0].Done).To(BeFalse())
+				Expect(snapshot[1].Done).To(BeTrue())
+			})
+			It("should count the cards of each column and their ages", func() {
+				snapshot, err := r.GetSnapshot(project, now)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snapshot[0].Count).To(Equal(3))
+				Expect(snapshot[0].MedianAgeHours).To(Equal(24.0))
+				Expect(snapshot[0].OldestAgeHours).To(Equal(48.0))
+				Expect(snapshot[1].Count).To(Equal(1))
+				Expect(snapshot[1].OldestAgeHours).To(Equal(1.0))
+			})
+		})
+	})
+	Describe("Issue Prefetching", func() {
+		Context("A repo with many cards and a report without a since", func() {
+			It("should list its closed Issues since the oldest card", func() {
+				oldest := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+				var since string
+				gh := newFakeGitHub(map[string]interface{}{
+					"GET /repos/secberus/api/issues": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						since = r.URL.Query().Get("since")
+						var issues []map[string]interface{}
+						for n := 1; n <= 12; n++ {
+							issues = append(issues, map[string]interface{}{"number": n, "state": "closed", "title": "Item " + strconv.Itoa(n)})
+						}
+						json.NewEncoder(w).Encode(issues)
+					}),
+				})
+				defer gh.Close()
+				var cards []*github.ProjectCard
+				for n := 1; n <= 12; n++ {
+					cards = append(cards, &github.ProjectCard{
+						ID:         github.Int64(int64(100 + n)),
+						ContentURL: github.String(gh.URL + "/repos/secberus/api/issues/" + strconv.Itoa(n)),
+						CreatedAt:  &github.Timestamp{Time: oldest.AddDate(0, 0, n)},
+						Creator:    &github.User{},
+					})
+				}
+				cards[5].CreatedAt = &github.Timestamp{Time: oldest}
+				r := utils.NewReporter(gh.GH())
+				typed := r.GetContentTypes(cards)
+				Expect(typed).To(HaveLen(12))
+				Expect(typed[0].Name).To(Equal("Item 1"))
+				Expect(since).To(Equal(oldest.Format(time.RFC3339)))
+				Expect(gh.Requests()).NotTo(ContainElement(HavePrefix("GET /repos/secberus/api/issues/")))
+			})
+		})
+	})
+	Describe("Charts", func() {
+		Context("A milestone past the first page", func() {
+			It("should be found", func() {
+				gh := newFakeGitHub(map[string]interface{}{
+					"GET /repos/secberus/api/milestones": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if r.URL.Query().Get("page") == "" {
+							w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next"`)
+							json.NewEncoder(w).Encode([]map[string]interface{}{{"number": 1, "title": "v1"}})
+							return
+						}
+						json.NewEncoder(w).Encode([]map[string]interface{}{{"number": 2, "title": "v2"}})
+					}),
+					"GET /repos/secberus/api/issues": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						json.NewEncoder(w).Encode([]map[string]interface{}{{"number": 7, "title": "milestone " + r.URL.Query().Get("milestone")}})
+					}),
+				})
+				defer gh.Close()
+				issues := gh.GH().ListMilestoneIssues("api", "v2")
+				Expect(issues).To(HaveLen(1))
+				Expect(issues[0].GetTitle()).To(Equal("milestone 2"))
+			})
+		})
+		Context("A milestone burndown across repos past the first page", func() {
+			It("should cover every repo", func() {
+				gh := newFakeGitHub(map[string]interface{}{
+					"GET /orgs/secberus/repos": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if r.URL.Query().Get("page") == "" {
+							w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next"`)
+							json.NewEncoder(w).Encode([]map[string]interface{}{{"name": "api"}})
+							return
+						}
+						json.NewEncoder(w).Encode([]map[string]interface{}{{"name": "web"}})
+					}),
+					"GET /repos/secberus/api/milestones": []map[string]interface{}{},
+					"GET /repos/secberus/web/milestones": []map[string]interface{}{},
+				})
+				defer gh.Close()
+				r := &utils.Reporter{GH: gh.GH()}
+				r.GetMilestoneBurndown("", "v2", time.Now().AddDate(0, 0, -1), time.Now())
+				Expect(gh.Requests()).To(ContainElement("GET /repos/secberus/web/milestones"))
+			})
+		})
+		day := func(d int, h int) *time.Time {
+			t := time.Date(2020, 6, d, h, 0, 0, 0, time.UTC)
+			return &t
+		}
+		Context("A burndown", func() {
+			issues := []*github.Issue{
+				{CreatedAt: day(1, 9)},
+				{CreatedAt: day(1, 10), ClosedAt: day(2, 12)},
+				{CreatedAt: day(3, 8)},
+			}
+			series := utils.BurndownSeries(issues, *day(1, 0), *day(3, 12))
+			It("should have a point per day", func() {
+				Expect(series).To(HaveLen(3))
+				Expect(series[0].Date).To(Equal("2020-06-01"))
+			})
+			It("should count items open and closed at the end of each day", func() {
+				Expect(*series[0]).To(Equal(utils.BurndownPoint{Date: "2020-06-01", Open: 2, Closed: 0}))
+				Expect(*series[1]).To(Equal(utils.BurndownPoint{Date: "2020-06-02", Open: 1, Closed: 1}))
+				Expect(*series[2]).To(Equal(utils.BurndownPoint{Date: "2020-06-03", Open: 2, Closed: 1}))
+			})
+		})
+		Context("A cumulative flow", func() {
+			events := [][]utils.CardEvent{
+				{{Action: "created", Column: "To Do", At: *day(1, 9)}, {Action: "moved", Column: "Done", At: *day(2, 9)}},
+				{{Action: "created", Column: "To Do", At: *day(2, 9)}, {Action: "deleted", Column: "To Do", At: *day(3, 9)}},
+			}
+			series := utils.CumulativeFlowSeries(events, []string{"To Do", "Done"}, *day(1, 0), *day(3, 0))
+			It("should count the cards in each column at the end of each day", func() {
+				Expect(series[0].Columns).To(Equal(map[string]int{"To Do": 1, "Done": 0}))
+				Expect(series[1].Columns).To(Equal(map[string]int{"To Do": 1, "Done": 1}))
+				Expect(series[2].Columns).To(Equal(map[string]int{"To Do": 0, "Done": 1}))
+			})
+			It("should render as SVG", func() {
+				var b strings.Builder
+				Expect(utils.RenderCumulativeFlowSVG(&b, &utils.CumulativeFlow{Name: "Kanban", Columns: []string{"To Do", "Done"}, Series: series})).To(Succeed())
+				Expect(b.String()).To(HavePrefix("<svg"))
+				Expect(strings.Count(b.String(), "<polygon")).To(Equal(2))
+			})
+		})
+	})
+	Describe("Metrics", func() {
+		Context("GitHub API paths", func() {
+			It("should be templated", func() {
+				Expect(utils.APIEndpoint("/repos/secberus/api/issues/12/labels")).To(Equal("/repos/:owner/:repo/issues/:id/labels"))
+				Expect(utils.APIEndpoint("/projects/columns/34/cards")).To(Equal("/projects/columns/:id/cards"))
+				Expect(utils.APIEndpoint("/orgs/secberus/projects")).To(Equal("/orgs/:org/projects"))
+				Expect(utils.APIEndpoint("/repos/secberus/api/collaborators/alice/permission")).To(Equal("/repos/:owner/:repo/collaborators/:user/permission"))
+			})
+		})
+	})
+	Describe("Logging", func() {
+		Context("A project card event", func() {
+			It("should be tagged with the repo and number of its content", func() {
+				url := "https://api.github.com/repos/secberus/api/issues/7"
+				id := int64(3)
+				fields := utils.EventFields(&github.ProjectCardEvent{ProjectCard: &github.ProjectCard{ID: &id, ContentURL: &url}})
+				Expect(fields).To(HaveKeyWithValue("repo", "api"))
+				Expect(fields).To(HaveKeyWithValue("number", 7))
+				Expect(fields).To(HaveKeyWithValue("card", id))
+			})
+		})
+	})
+	Describe("Admin Auth", func() {
+		AfterEach(func() {
+			viper.Set("admin_tokens", "")
+		})
+		Context("A configured admin token", func() {
+			It("should be accepted", func() {
+				viper.Set("admin_tokens", "first, second")
+				Expect(utils.NewAuthenticator(nil).Authenticate("second")).To(Equal("admin"))
+			})
+		})
+		Context("An unknown token without GitHub auth", func() {
+			It("should be rejected", func() {
+				viper.Set("admin_tokens", "first")
+				_, err := utils.NewAuthenticator(nil).Authenticate("other")
+				Expect(err).To(MatchError(utils.ErrUnauthorized))
+			})
+		})
+		Context("No tokens configured", func() {
+			It("should reject a missing token", func() {
+				a := utils.NewAuthenticator(nil)
+				Expect(a.Enabled()).To(BeFalse())
+				_, err := a.Authenticate("")
+				Expect(err).To(MatchError(utils.ErrUnauthorized))
+			})
+		})
+	})
+	Describe("Config Redaction", func() {
+		Context("Report sinks in the rules config", func() {
+			settings := map[string]interface{}{
+				"reportsinks": []interface{}{
+					map[interface{}]interface{}{"name": "slack", "url": "https://hooks.slack.com/services/T/B/secret"},
+				},
+				"hook_url": "http://projector.test/webhook",
+				"token":    "",
 			}
-			hook = &github.Hook{
-				ID:     &hookID,
-				Config: hookConfig,
-				Events: []string{"pull_request"},
+			redacted := utils.Redact(settings)
+			It("should hide their URLs", func() {
+				sink := redacted["reportsinks"].([]interface{})[0].(map[interface{}]interface{})
+				Expect(sink["url"]).To(Equal("REDACTED"))
+				Expect(sink["name"]).To(Equal("slack"))
+			})
+			It("should leave the settings untouched", func() {
+				sink := settings["reportsinks"].([]interface{})[0].(map[interface{}]interface{})
+				Expect(sink["url"]).To(HavePrefix("https://"))
+				Expect(redacted["hook_url"]).To(Equal("http://projector.test/webhook"))
+				Expect(redacted["token"]).To(Equal(""))
+			})
+		})
+	})
+	Describe("Readiness", func() {
+		Context("A token with every scope", func() {
+			It("should miss nothing", func() {
+				Expect(utils.MissingScopes("repo, admin:org_hook, admin:org", []string{"repo", "admin:org_hook", "read:org"})).To(BeEmpty())
+			})
+		})
+		Context("A token without hook scopes", func() {
+			It("should miss them", func() {
+				Expect(utils.MissingScopes("repo, read:org", []string{"repo", "admin:org_hook", "read:org"})).To(Equal([]string{"admin:org_hook"}))
+			})
+		})
+		Context("A default project created after start up", func() {
+			It("should be found when loading again", func() {
+				viper.Set("default_project", "Kanban")
+				viper.Set("default_column", "To Do")
+				defer viper.Set("default_project", "")
+				defer viper.Set("default_column", "")
+				fake := newFakeGitHub(map[string]interface{}{
+					"GET /orgs/secberus/projects": []map[string]interface{}{},
+				})
+				defer fake.Close()
+				gh := fake.GH()
+				Expect(gh.LoadDefaultProject()).NotTo(Succeed())
+				fake.mu.Lock()
+				fake.routes["GET /orgs/secberus/projects"] = []map[string]interface{}{{"id": 1, "name": "Kanban"}}
+				fake.routes["GET /projects/1/columns"] = []map[string]interface{}{{"id": 10, "name": "To Do"}}
+				fake.mu.Unlock()
+				done := make(chan bool)
+				go func() {
+					defer close(done)
+					for i := 0; i < 10; i++ {
+						gh.WithLogger(logrus.NewEntry(logrus.StandardLogger())).CheckDefaultProject()
+					}
+				}()
+				Expect(gh.LoadDefaultProject()).To(Succeed())
+				<-done
+				Expect(gh.CheckDefaultProject()).To(Succeed())
+			})
+		})
+		Context("A rule project created after start up", func() {
+			It("should be found by the rules once the projects are reloaded", func() {
+				fake := newFakeGitHub(map[string]interface{}{
+					"GET /orgs/secberus/projects": []map[string]interface{}{{"id": 1, "name": "Kanban"}},
+				})
+				defer fake.Close()
+				gh := fake.GH()
+				rules := fake.RulesProcessor(gh, `
+LabelRules:
+- name: Bugs
+  label: bug
+  project: Bugs
+  column: Triage
+WIPLimits:
+- project: Kanban
+  column: Review
+  limit: 1
+  action: comment
+`)
+				err := rules.CheckRuleProjects()
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("projects Bugs of the rules"))
+				fake.mu.Lock()
+				fake.routes["GET /orgs/secberus/projects"] = []map[string]interface{}{{"id": 1, "name": "Kanban"}, {"id": 2, "name": "Bugs"}}
+				fake.mu.Unlock()
+				gh.ReloadProjects()
+				Expect(rules.CheckRuleProjects()).To(Succeed())
+			})
+		})
+		Context("A failed check", func() {
+			It("should carry its error", func() {
+				Expect(utils.NewCheck("hook", errors.New("hook 1 is inactive"))).To(Equal(utils.Check{Name: "hook", Error: "hook 1 is inactive"}))
+			})
+		})
+	})
+	Describe("Label Rules", func() {
+		rule := utils.LabelRule{Name: "Bugs", Label: "type: bug", Project: "Bugs", Column: "Triage", State: "open", Content: "Issue"}
+		Context("A complete rule", func() {
+			It("should be valid", func() {
+				Expect(rule.Validate()).To(Succeed())
+			})
+		})
+		Context("A rule for unknown content", func() {
+			It("should be invalid", func() {
+				r := rule
+				r.Content = "Discussion"
+				Expect(r.Validate()).NotTo(Succeed())
+			})
+		})
+	})
+	Describe("WIP Limits", func() {
+		var (
+			fake  *fakeGitHub
+			rules *utils.RulesProcessor
+		)
+		config := `
+WIPLimits:
+- project: Kanban
+  column: In Progress
+  limit: 2
+  action: label
+  label: overflow
+- project: Kanban
+  column: Review
+  limit: 1
+  action: comment
+- project: Kanban
+  column: Review
+  limit: 1
+  action: label
+- project: Kanban
+  column: To Do
+  limit: 1
+  action: refuse
+LabelRules:
+- name: Bugs
+  label: bug
+  project: Kanban
+  column: To Do
+  state: open
+  content: Issue
+`
+		cards := func(numbers ...int) []map[string]interface{} {
+			var c []map[string]interface{}
+			for _, n := range numbers {
+				c = append(c, map[string]interface{}{"id": 100 + n, "content_url": fake.URL + "/repos/secberus/api/issues/" + strconv.Itoa(n)})
 			}
-			hooks = append(hooks, hook)
+			return c
+		}
+		BeforeEach(func() {
+			fake = newFakeGitHub(map[string]interface{}{
+				"GET /orgs/secberus/projects": []map[string]interface{}{{"id": 1, "name": "Kanban"}},
+				"GET /projects/1/columns":     []map[string]interface{}{{"id": 10, "name": "To Do"}, {"id": 11, "name": "In Progress"}, {"id": 12, "name": "Review"}},
+			})
+			fake.routes["GET /projects/columns/10/cards"] = cards(1)
+			fake.routes["GET /projects/columns/11/cards"] = cards(2, 3, 4, 5)
+			fake.routes["GET /projects/columns/12/cards"] = cards(6, 7)
+			fake.routes["POST /repos/secberus/api/issues/4/labels"] = []map[string]interface{}{{"name": "overflow"}}
+			fake.routes["POST /repos/secberus/api/issues/5/labels"] = []map[string]interface{}{{"name": "overflow"}}
+			fake.routes["POST /repos/secberus/api/issues/6/comments"] = map[string]interface{}{"id": 1}
+			rules = fake.RulesProcessor(fake.GH(), config)
+		})
+		AfterEach(func() {
+			fake.Close()
+		})
+		moved := func(card map[string]interface{}, column int64) *github.ProjectCardEvent {
+			url := card["content_url"].(string)
+			return &github.ProjectCardEvent{
+				Action:      github.String("moved"),
+				ProjectCard: &github.ProjectCard{ID: github.Int64(int64(card["id"].(int))), ContentURL: &url, ColumnID: &column},
+			}
+		}
+		Context("A label action without a label", func() {
+			It("should be ignored", func() {
+				Expect(utils.WIPLimit{Project: "Kanban", Column: "Review", Limit: 1, Action: "label"}.Validate()).NotTo(Succeed())
+				Expect(rules.WIPLimits()).To(HaveLen(3))
+			})
+		})
+		Context("A column over its limit with the label action", func() {
+			It("should label the cards past the limit", func() {
+				Expect(rules.ProcessProjectCardEvent(moved(cards(5)[0], 11))).To(Succeed())
+				Expect(fake.Requests()).To(ContainElement("POST /repos/secberus/api/issues/4/labels"))
+				Expect(fake.Requests()).To(ContainElement("POST /repos/secberus/api/issues/5/labels"))
+				Expect(fake.Requests()).NotTo(ContainElement("POST /repos/secberus/api/issues/3/labels"))
+				Expect(fake.Body("POST /repos/secberus/api/issues/5/labels")).To(ContainSubstring("overflow"))
+			})
+		})
+		Context("A column over its limit with the comment action", func() {
+			It("should comment on the card that entered it", func() {
+				Expect(rules.ProcessProjectCardEvent(moved(cards(6)[0], 12))).To(Succeed())
+				Expect(fake.Requests()).To(ContainElement("POST /repos/secberus/api/issues/6/comments"))
+				Expect(fake.Body("POST /repos/secberus/api/issues/6/comments")).To(ContainSubstring("Review is over its WIP limit of 1."))
+			})
+		})
+		Context("A column at its limit with the refuse action", func() {
+			It("should skip automated moves into it", func() {
+				event := &github.IssuesEvent{
+					Action: github.String("labeled"),
+					Label:  &github.Label{Name: github.String("bug")},
+					Issue:  &github.Issue{ID: github.Int64(8), State: github.String("open")},
+					Repo:   &github.Repository{Name: github.String("api")},
+				}
+				Expect(rules.ProcessLabelRules(event)).To(Succeed())
+				Expect(fake.Requests()).To(ContainElement("GET /projects/columns/10/cards"))
+				Expect(fake.Requests()).NotTo(ContainElement("POST /projects/columns/10/cards"))
+			})
+		})
+	})
+	Describe("Webhook Queue", func() {
+		Context("A delivery failing once", func() {
+			It("should be retried until it succeeds", func() {
+				attempts := make(chan int, 10)
+				q := utils.NewQueue(10, func(j *utils.Job) error {
+					attempts <- j.Attempts
+					if j.Attempts < 2 {
+						return errors.New("bad gateway")
+					}
+					return nil
+				})
+				q.Backoff = time.Millisecond
+				q.Start(1)
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "1"})).To(Succeed())
+				Eventually(attempts).Should(Receive(Equal(1)))
+				Eventually(attempts).Should(Receive(Equal(2)))
+				Consistently(attempts, "20ms").ShouldNot(Receive())
+			})
+		})
+		Context("A delivery that keeps failing", func() {
+			It("should be given up after MaxAttempts", func() {
+				attempts := make(chan int, 10)
+				q := utils.NewQueue(10, func(j *utils.Job) error {
+					attempts <- j.Attempts
+					return errors.New("bad gateway")
+				})
+				dead := make(chan *utils.Job, 1)
+				q.Backoff, q.MaxAttempts = time.Millisecond, 2
+				q.OnGiveUp = func(j *utils.Job) { dead <- j }
+				q.Start(1)
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "1"})).To(Succeed())
+				Eventually(attempts).Should(Receive(Equal(1)))
+				Eventually(attempts).Should(Receive(Equal(2)))
+				Consistently(attempts, "20ms").ShouldNot(Receive())
+				var j *utils.Job
+				Eventually(dead).Should(Receive(&j))
+				Expect(j.LastError).To(Equal("bad gateway"))
+			})
+		})
+		Context("A stopped queue", func() {
+			It("should process the queued deliveries and refuse new ones", func() {
+				release := make(chan bool)
+				processed := make(chan string, 10)
+				q := utils.NewQueue(10, func(j *utils.Job) error {
+					<-release
+					processed <- j.DeliveryID
+					return nil
+				})
+				q.Start(1)
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "1"})).To(Succeed())
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "2"})).To(Succeed())
+				stopped := make(chan error, 1)
+				go func() { stopped <- q.Stop(context.Background()) }()
+				// deliveries accepted before Stop are processed as well
+				accepted := 2
+				Eventually(func() error {
+					err := q.Enqueue(&utils.Job{DeliveryID: "3"})
+					if err == nil {
+						accepted++
+					}
+					return err
+				}).Should(MatchError(utils.ErrQueueStopped))
+				close(release)
+				Eventually(stopped).Should(Receive(BeNil()))
+				Expect(processed).To(HaveLen(accepted))
+			})
+			It("should give up deliveries waiting for a retry", func() {
+				attempts := make(chan int, 10)
+				q := utils.NewQueue(10, func(j *utils.Job) error {
+					attempts <- j.Attempts
+					return errors.New("bad gateway")
+				})
+				dead := make(chan *utils.Job, 1)
+				q.Backoff = time.Hour
+				q.OnGiveUp = func(j *utils.Job) { dead <- j }
+				q.Start(1)
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "1"})).To(Succeed())
+				Eventually(attempts).Should(Receive(Equal(1)))
+				Expect(q.Stop(context.Background())).To(Succeed())
+				var j *utils.Job
+				Expect(dead).To(Receive(&j))
+				Expect(j.Attempts).To(Equal(1))
+			})
+			It("should give up the deliveries still queued when it times out", func() {
+				release := make(chan bool)
+				defer close(release)
+				q := utils.NewQueue(10, func(j *utils.Job) error {
+					<-release
+					return nil
+				})
+				dead := make(chan *utils.Job, 1)
+				q.OnGiveUp = func(j *utils.Job) { dead <- j }
+				q.Start(1)
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "1"})).To(Succeed())
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "2"})).To(Succeed())
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				defer cancel()
+				Expect(q.Stop(ctx)).To(MatchError(context.DeadlineExceeded))
+				var j *utils.Job
+				Expect(dead).To(Receive(&j))
+				Expect(j.DeliveryID).To(Equal("2"))
+			})
 		})
-		Context("A hook that contains PRJ_HOOK_URL in its URL", func() {
-			It("should not be recreated", func() {
-				viper.Set("hook_url", "http://www.test.com")
-				Expect(utils.NewGH().HookExists(hooks)).To(Equal(true))
+		Context("A full queue", func() {
+			It("should reject deliveries", func() {
+				q := utils.NewQueue(1, func(j *utils.Job) error { return nil })
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "1"})).To(Succeed())
+				Expect(q.Enqueue(&utils.Job{DeliveryID: "2"})).To(MatchError(utils.ErrQueueFull))
 			})
 		})
-		Context("No hooks contain PRJ_HOOK_URL in its URL", func() {
-			It("should not be recreated", func() {
-				viper.Set("hook_url", "http://www.test1.com")
-				Expect(utils.NewGH().HookExists(hooks)).To(Equal(false))
+		Context("A retried delivery", func() {
+			It("should only run the steps that failed", func() {
+				j := &utils.Job{DeliveryID: "1"}
+				comments, cards := 0, 0
+				comment := func() error { comments++; return nil }
+				card := func() error {
+					if cards++; cards == 1 {
+						return errors.New("bad gateway")
+					}
+					return nil
+				}
+				Expect(j.Step("comment", comment)).To(Succeed())
+				Expect(j.Step("card", card)).NotTo(Succeed())
+				Expect(j.Step("comment", comment)).To(Succeed())
+				Expect(j.Step("card", card)).To(Succeed())
+				Expect(comments).To(Equal(1))
+				Expect(cards).To(Equal(2))
+				Expect(j.Done).To(Equal([]string{"comment", "card"}))
+			})
+		})
+		Context("A card already on the board", func() {
+			It("should count as created", func() {
+				gh := newFakeGitHub(map[string]interface{}{
+					"POST /projects/columns/10/cards": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusUnprocessableEntity)
+						w.Write([]byte(`{"message":"Validation Failed","errors":[{"resource":"ProjectCard","code":"unprocessable","field":"data","message":"Project already has the associated issue"}]}`))
+					}),
+				})
+				defer gh.Close()
+				Expect(gh.GH().CreateProjectCard("Issue", 1, 10)).To(Succeed())
+			})
+			It("should not fail a retry after a lost response", func() {
+				attempts := 0
+				gh := newFakeGitHub(map[string]interface{}{
+					"POST /projects/columns/10/cards": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if attempts++; attempts == 1 {
+							w.WriteHeader(http.StatusBadGateway)
+							return
+						}
+						w.WriteHeader(http.StatusUnprocessableEntity)
+						w.Write([]byte(`{"message":"Validation Failed","errors":[{"message":"Project already has the associated issue"}]}`))
+					}),
+				})
+				defer gh.Close()
+				client := gh.GH()
+				Expect(client.CreateProjectCard("Issue", 1, 10)).To(Succeed())
+				Expect(attempts).To(Equal(2))
+				Expect(client.FailedRequests.List()).To(BeEmpty())
 			})
 		})
 	})
 })
+
+// roundTripper fakes GitHub responses
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// fakeGitHub serves canned JSON for "METHOD /path" routes and records the
+// requests and bodies it receives. An int route answers with that status, a
+// http.HandlerFunc route answers itself.
+type fakeGitHub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	routes   map[string]interface{}
+	requests []string
+	bodies   map[string]string
+}
+
+func newFakeGitHub(routes map[string]interface{}) *fakeGitHub {
+	f := &fakeGitHub{routes: routes, bodies: map[string]string{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.Path
+		body, _ := ioutil.ReadAll(r.Body)
+		f.mu.Lock()
+		f.requests = append(f.requests, key)
+		f.bodies[key] = string(body)
+		rsp, ok := f.routes[key]
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case !ok:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		case rsp == nil:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			if h, ok := rsp.(http.HandlerFunc); ok {
+				h(w, r)
+				return
+			}
+			if status, ok := rsp.(int); ok {
+				w.WriteHeader(status)
+				w.Write([]byte(`{"message":"` + http.StatusText(status) + `"}`))
+				return
+			}
+			if r.Method == "POST" {
+				w.WriteHeader(http.StatusCreated)
+			}
+			json.NewEncoder(w).Encode(rsp)
+		}
+	}))
+	return f
+}
+
+// Configure points new clients at the fake GitHub for the secberus org until
+// reset is called
+func (f *fakeGitHub) Configure() (reset func()) {
+	viper.Set("org_name", "secberus")
+	viper.Set("github_api_url", f.URL)
+	return func() {
+		viper.Set("org_name", "")
+		viper.Set("github_api_url", "")
+	}
+}
+
+// GH returns a client of the fake GitHub
+func (f *fakeGitHub) GH() *utils.GH {
+	defer f.Configure()()
+	return utils.NewGH()
+}
+
+// RulesProcessor returns a RulesProcessor sharing gh, a client of the fake
+// GitHub, loading config as its rules config file
+func (f *fakeGitHub) RulesProcessor(gh *utils.GH, config string) *utils.RulesProcessor {
+	// the rules config is looked up in the working directory
+	wd, err := os.Getwd()
+	Expect(err).NotTo(HaveOccurred())
+	dir, err := ioutil.TempDir("", "projector")
+	Expect(err).NotTo(HaveOccurred())
+	defer os.RemoveAll(dir)
+	Expect(ioutil.WriteFile(filepath.Join(dir, ".prj.yaml"), []byte(config), 0600)).To(Succeed())
+	Expect(os.Chdir(dir)).To(Succeed())
+	defer os.Chdir(wd)
+	return utils.NewRulesProcessor(gh)
+}
+
+// Requests lists the requests received so far
+func (f *fakeGitHub) Requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+// Body returns the last body received for a route
+func (f *fakeGitHub) Body(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bodies[key]
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportWindow limits reports to items closed between Since and Until.
+// A zero time leaves that side of the window open.
+type ReportWindow struct {
+	Since time.Time
+	Until time.Time
+}
+
+// ParseReportWindow builds a window from since/until timestamps or a relative
+// last duration such as 14d, 2w or 36h
+func ParseReportWindow(since string, until string, last string, now time.Time) (ReportWindow, error) {
+	var w ReportWindow
+	var err error
+	if last != "" {
+		if since != "" {
+			return w, fmt.Errorf("last and since can't be combined")
+		}
+		d, err := ParseDuration(last)
+		if err != nil {
+			return w, err
+		}
+		w.Since = now.Add(-d)
+	}
+	if since != "" {
+		if w.Since, err = parseTime(since, false); err != nil {
+			return w, err
+		}
+	}
+	if until != "" {
+		if w.Until, err = parseTime(until, true); err != nil {
+			return w, err
+		}
+	}
+	if !w.Since.IsZero() && !w.Until.IsZero() && w.Until.Before(w.Since) {
+		return w, fmt.Errorf("until %s is before since %s", until, since)
+	}
+	return w, nil
+}
+
+// MinVelocityPeriod is the shortest velocity period reports accept
+const MinVelocityPeriod = time.Hour
+
+// CheckVelocityPeriod makes sure a velocity period is at least
+// MinVelocityPeriod and splits the window into no more than the periods
+// reports keep
+func CheckVelocityPeriod(w ReportWindow, period time.Duration, now time.Time) error {
+	if period < MinVelocityPeriod {
+		return fmt.Errorf("period must be at least %s", MinVelocityPeriod)
+	}
+	if w.Since.IsZero() {
+		// open windows are capped to the most recent periods
+		return nil
+	}
+	until := w.Until
+	if until.IsZero() {
+		until = now
+	}
+	if n := until.Sub(w.Since) / period; n > maxVelocityPeriods {
+		return fmt.Errorf("window spans %d periods, at most %d are allowed", n, maxVelocityPeriods)
+	}
+	return nil
+}
+
+// ParseDuration extends time.ParseDuration with days (d) and weeks (w)
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if strings.HasSuffix(s, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// parseTime parses an RFC3339 time or a date. With endOfDay a date covers the
+// whole day, so an until date includes it.
+func parseTime(s string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, use RFC3339 or YYYY-MM-DD", s)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// IsZero checks if the window is open on both sides
+func (w ReportWindow) IsZero() bool {
+	return w.Since.IsZero() && w.Until.IsZero()
+}
+
+// Contains checks if a time falls inside the window
+func (w ReportWindow) Contains(t time.Time) bool {
+	if !w.Since.IsZero() && t.Before(w.Since) {
+		return false
+	}
+	if !w.Until.IsZero() && t.After(w.Until) {
+		return false
+	}
+	return true
+}
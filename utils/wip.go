@@ -0,0 +1,182 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+
+	github "github.com/google/go-github/v32/github"
+	"github.com/sirupsen/logrus"
+)
+
+// WIP limit actions
+const (
+	// WIPRefuse skips automated moves into a full column
+	WIPRefuse = "refuse"
+	// WIPComment comments on the item that pushed a column over its limit
+	WIPComment = "comment"
+	// WIPLabel labels the cards past the limit of a column
+	WIPLabel = "label"
+)
+
+// WIPLimit defines the maximum number of cards allowed in a column
+type WIPLimit struct {
+	Project string
+	Column  string
+	Limit   int
+	Action  string
+	Label   string
+	Comment string
+}
+
+// ErrWIPLimit refuses an automated move into a column at its WIP limit
+var ErrWIPLimit = errors.New("column is at its WIP limit")
+
+// ColumnWIP shows the current work in progress of a column against its limit
+type ColumnWIP struct {
+	Column string `json:"Column"`
+	Count  int    `json:"Count"`
+	Limit  int    `json:"Limit"`
+}
+
+// Validate checks a WIP limit has a column, a limit and an action it can
+// carry out
+func (l WIPLimit) Validate() error {
+	switch {
+	case l.Project == "" || l.Column == "":
+		return errors.New("project and column are required")
+	case l.Limit < 1:
+		return fmt.Errorf("limit must be at least 1, not %d", l.Limit)
+	case l.Action != WIPRefuse && l.Action != WIPComment && l.Action != WIPLabel:
+		return fmt.Errorf("action must be refuse, comment or label, not %q", l.Action)
+	case l.Action == WIPLabel && l.Label == "":
+		return errors.New("label is required by the label action")
+	}
+	return nil
+}
+
+// WIPLimits returns the configured WIP limits, leaving out invalid ones
+func (r *RulesProcessor) WIPLimits() []WIPLimit {
+	var limits []WIPLimit
+	if err := r.decodeRules("WIPLimits", &limits); err != nil {
+		r.log.WithError(err).Error("Error Decoding WIP Limits")
+	}
+	valid := limits[:0]
+	for _, l := range limits {
+		if err := l.Validate(); err != nil {
+			r.log.WithError(err).WithFields(logrus.Fields{"project": l.Project, "column": l.Column}).Error("Ignoring Invalid WIP Limit")
+			continue
+		}
+		valid = append(valid, l)
+	}
+	return valid
+}
+
+// wipAllows checks if an automated move into a column respects refusing WIP limits
+func (r *RulesProcessor) wipAllows(project string, colID int64) (bool, error) {
+	for _, l := range r.WIPLimits() {
+		if l.Project != project || l.Action != WIPRefuse {
+			continue
+		}
+		id, err := r.wipColumnID(l)
+		if err != nil {
+			return false, err
+		}
+		if id != colID {
+			continue
+		}
+		cards, err := r.gh.ListProjectCards(colID)
+		if err != nil {
+			return false, err
+		}
+		if len(cards) >= l.Limit {
+			r.log.WithFields(logrus.Fields{"column": l.Column, "limit": l.Limit}).Info("Refusing move, column is at its WIP limit")
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// wipColumnID returns the ID of the column of a WIP limit, 0 when the project
+// has no such column
+func (r *RulesProcessor) wipColumnID(l WIPLimit) (int64, error) {
+	projID, err := r.gh.GetProjectID(l.Project)
+	if err != nil {
+		return 0, err
+	}
+	columns, err := r.gh.ListProjectColumns(projID)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := r.gh.GetCardColumnIDByName(columns, l.Column)
+	return id, nil
+}
+
+// ProcessProjectCardEvent enforces WIP limits when cards enter a column
+func (r *RulesProcessor) ProcessProjectCardEvent(e *github.ProjectCardEvent) error {
+	if e.GetAction() != "moved" && e.GetAction() != "created" {
+		return nil
+	}
+	card := e.GetProjectCard()
+	for _, l := range r.WIPLimits() {
+		colID, err := r.wipColumnID(l)
+		if err != nil {
+			return err
+		}
+		if colID == 0 || colID != card.GetColumnID() {
+			continue
+		}
+		cards, err := r.gh.ListProjectCards(colID)
+		if err != nil {
+			return err
+		}
+		if len(cards) <= l.Limit {
+			continue
+		}
+		r.log.WithFields(logrus.Fields{"column": l.Column, "limit": l.Limit}).Info("Column is over its WIP limit")
+		switch l.Action {
+		case WIPComment:
+			err = r.commentOverLimit(l, card)
+		case WIPLabel:
+			err = r.labelOverflow(l, cards)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RulesProcessor) commentOverLimit(l WIPLimit, card *github.ProjectCard) error {
+	if card.ContentURL == nil {
+		return nil
+	}
+	repo, number, ok := ParseContentURL(*card.ContentURL)
+	if !ok {
+		return nil
+	}
+	body := l.Comment
+	if body == "" {
+		body = fmt.Sprintf("%s is over its WIP limit of %d.", l.Column, l.Limit)
+	}
+	if err := r.gh.CreateComment(repo, number, body); err != nil {
+		return fmt.Errorf("commenting on WIP overflow of %s#%d: %w", repo, number, err)
+	}
+	return nil
+}
+
+// labelOverflow labels the cards at the bottom of a column past its limit
+func (r *RulesProcessor) labelOverflow(l WIPLimit, cards []*github.ProjectCard) error {
+	for _, card := range cards[l.Limit:] {
+		if card.ContentURL == nil {
+			continue
+		}
+		repo, number, ok := ParseContentURL(*card.ContentURL)
+		if !ok {
+			continue
+		}
+		if err := r.gh.AddLabels(repo, number, l.Label); err != nil {
+			return fmt.Errorf("labeling WIP overflow %s#%d: %w", repo, number, err)
+		}
+	}
+	return nil
+}